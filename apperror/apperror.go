@@ -44,3 +44,11 @@ func Unauthorized(message string, data any, err error) *AppError {
 func Forbidden(message string, data any, err error) *AppError {
 	return New(CodeForbidden, message, data, err)
 }
+
+func Conflict(message string, data any, err error) *AppError {
+	return New(CodeConflict, message, data, err)
+}
+
+func QuotaExceeded(message string, data any, err error) *AppError {
+	return New(CodeQuotaExceeded, message, data, err)
+}
@@ -3,8 +3,10 @@ package apperror
 type code string
 
 const (
-	CodeNotFound     code = "not_found"
-	CodeBadRequest   code = "bad_request"
-	CodeUnauthorized code = "unauthorized"
-	CodeForbidden    code = "forbidden"
+	CodeNotFound      code = "not_found"
+	CodeBadRequest    code = "bad_request"
+	CodeUnauthorized  code = "unauthorized"
+	CodeForbidden     code = "forbidden"
+	CodeConflict      code = "conflict"
+	CodeQuotaExceeded code = "quota_exceeded"
 )
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"backend/config"
+	"backend/database"
+	"backend/file"
+	"backend/logger"
+	"context"
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+
+	_ "github.com/lib/pq"
+	"go.uber.org/fx"
+)
+
+// Runs the storage janitor once and prints its report. Nothing is deleted or
+// repaired unless -dry-run=false is given.
+func main() {
+	dryRun := flag.Bool("dry-run", true, "report without deleting or repairing")
+	flag.Parse()
+
+	var janitor *file.Janitor
+	app := fx.New(
+		fx.NopLogger,
+		logger.Module,
+		config.Module,
+		database.Module,
+		file.JanitorModule,
+		fx.Populate(&janitor),
+	)
+
+	ctx := context.Background()
+	if err := app.Start(ctx); err != nil {
+		log.Fatal(err)
+	}
+	defer app.Stop(ctx)
+
+	report, err := janitor.Run(ctx, *dryRun)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		log.Fatal(err)
+	}
+}
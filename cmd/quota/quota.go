@@ -0,0 +1,67 @@
+package main
+
+import (
+	"backend/config"
+	"backend/database"
+	"backend/database/ent"
+	"backend/file"
+	"context"
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+
+	_ "github.com/lib/pq"
+	"go.uber.org/fx"
+)
+
+// Prints the storage usage of a user, after overriding their quota with -set
+// (in bytes) or restoring the default with -reset.
+func main() {
+	userId := flag.Int("user", 0, "id of the user")
+	set := flag.Int64("set", -1, "quota to set in bytes")
+	reset := flag.Bool("reset", false, "restore the default quota")
+	flag.Parse()
+
+	if *userId == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var client *ent.Client
+	var quota *file.Quota
+	app := fx.New(
+		fx.NopLogger,
+		config.Module,
+		database.Module,
+		file.QuotaModule,
+		fx.Populate(&client, &quota),
+	)
+
+	ctx := context.Background()
+	if err := app.Start(ctx); err != nil {
+		log.Fatal(err)
+	}
+	defer app.Stop(ctx)
+
+	if *set >= 0 || *reset {
+		p := &file.SetQuotaParams{UserId: *userId}
+		if !*reset {
+			p.Quota = set
+		}
+		if err := quota.SetQuota(ctx, client, p); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	usage, err := quota.GetUsage(ctx, client, *userId)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(usage); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"backend/database"
 	"backend/file"
 	"backend/http"
+	"backend/linkpreview"
 	"backend/logger"
 	"backend/notification"
 	"backend/security"
@@ -29,6 +30,7 @@ func main() {
 		security.Module,
 		user.Module,
 		file.Module,
+		linkpreview.Module,
 		conversation.Module,
 	).Run()
 }
@@ -0,0 +1,74 @@
+package common
+
+import (
+	"strings"
+
+	"github.com/cockroachdb/errors"
+)
+
+const (
+	UsernameMinLength = 3
+	UsernameMaxLength = 30
+)
+
+var (
+	ErrUsernameLength     = errors.Newf("Username must be between %d and %d characters", UsernameMinLength, UsernameMaxLength)
+	ErrUsernameCharacters = errors.New("Username may only contain lowercase letters, digits, '_' and '.'")
+	ErrUsernameDots       = errors.New("Username cannot start or end with '.' or contain consecutive dots")
+	ErrUsernameReserved   = errors.New("Username is reserved")
+)
+
+// reservedUsernames can never be claimed, either because they collide with
+// routes and system accounts or because they would be ambiguous in @mentions.
+var reservedUsernames = map[string]struct{}{
+	"admin":         {},
+	"administrator": {},
+	"all":           {},
+	"api":           {},
+	"channel":       {},
+	"everyone":      {},
+	"here":          {},
+	"help":          {},
+	"me":            {},
+	"moderator":     {},
+	"null":          {},
+	"profile":       {},
+	"root":          {},
+	"search":        {},
+	"support":       {},
+	"system":        {},
+	"undefined":     {},
+}
+
+// NormalizeUsername lowercases the username and strips a leading '@', so that
+// usernames are unique regardless of case.
+func NormalizeUsername(username string) string {
+	return strings.ToLower(strings.TrimPrefix(strings.TrimSpace(username), "@"))
+}
+
+// ValidateUsername expects a username already passed through NormalizeUsername.
+func ValidateUsername(username string) error {
+	if len(username) < UsernameMinLength || len(username) > UsernameMaxLength {
+		return ErrUsernameLength
+	}
+
+	for _, r := range username {
+		if !IsUsernameRune(r) {
+			return ErrUsernameCharacters
+		}
+	}
+
+	if strings.HasPrefix(username, ".") || strings.HasSuffix(username, ".") || strings.Contains(username, "..") {
+		return ErrUsernameDots
+	}
+
+	if _, ok := reservedUsernames[username]; ok {
+		return ErrUsernameReserved
+	}
+
+	return nil
+}
+
+func IsUsernameRune(r rune) bool {
+	return (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_' || r == '.'
+}
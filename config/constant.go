@@ -1,5 +1,27 @@
 package config
 
 const (
-	VerifySignInExpiresInMinute = 10
+	VerifySignInExpiresInMinute  = 10
+	UsernameChangeIntervalInDay  = 7
+	MaxPinnedConversations       = 5
+	MaxScheduleAheadInDay        = 365
+	SchedulerIntervalInSecond    = 10
+	SchedulerBatchSize           = 100
+	SchedulerMaxAttempts         = 5
+	ReaperIntervalInSecond       = 60
+	ReaperBatchSize              = 500
+	MaxPollOptions               = 10
+	MaxLinkPreviewsPerMessage    = 3
+	LinkPreviewWorkers           = 4
+	LinkPreviewQueueSize         = 1000
+	LinkPreviewCacheInHour       = 24
+	MaxMediaNameLength           = 255
+	UploadExpiresInHour          = 24
+	UploadReaperIntervalInSecond = 300
+	UploadReaperBatchSize        = 100
+	TemporaryFileExpiresInHour   = 24
+	DraftMediaExpiresInDay       = 7
+	OrphanedFileGraceInHour      = 1
+	JanitorIntervalInMinute      = 60
+	JanitorBatchSize             = 500
 )
@@ -19,6 +19,17 @@ type Env struct {
 	MailPort                int           `mapstructure:"MAIL_PORT"`
 	MailUser                string        `mapstructure:"MAIL_USER"`
 	MailPassword            string        `mapstructure:"MAIL_PASSWORD"`
+	StorageDriver           string        `mapstructure:"STORAGE_DRIVER"`
+	S3Endpoint              string        `mapstructure:"S3_ENDPOINT"`
+	S3Region                string        `mapstructure:"S3_REGION"`
+	S3Bucket                string        `mapstructure:"S3_BUCKET"`
+	S3AccessKeyId           string        `mapstructure:"S3_ACCESS_KEY_ID"`
+	S3SecretAccessKey       string        `mapstructure:"S3_SECRET_ACCESS_KEY"`
+	S3UsePathStyle          bool          `mapstructure:"S3_USE_PATH_STYLE"`
+	S3PresignExpiresIn      time.Duration `mapstructure:"S3_PRESIGN_EXPIRES_IN"`
+	FileUrlSecretKey        string        `mapstructure:"FILE_URL_SECRET_KEY"`
+	FileUrlExpiresIn        time.Duration `mapstructure:"FILE_URL_EXPIRES_IN"`
+	StorageQuotaInMb        int64         `mapstructure:"STORAGE_QUOTA_IN_MB"`
 }
 
 func newEnv() (*Env, error) {
@@ -49,10 +60,30 @@ func newEnv() (*Env, error) {
 		return nil, errors.Wrap(err, "failed to unmarshal config")
 	}
 
+	// Các giá trị bắt buộc
+	if env.FileUrlSecretKey == "" {
+		return nil, errors.New("FILE_URL_SECRET_KEY is required")
+	}
+
 	// Thiết lập các giá trị mặc định
 	if env.Port == "" {
 		env.Port = "3000"
 	}
+	if env.StorageDriver == "" {
+		env.StorageDriver = "local"
+	}
+	if env.S3Region == "" {
+		env.S3Region = "us-east-1"
+	}
+	if env.S3PresignExpiresIn == 0 {
+		env.S3PresignExpiresIn = 15 * time.Minute
+	}
+	if env.FileUrlExpiresIn == 0 {
+		env.FileUrlExpiresIn = time.Hour
+	}
+	if env.StorageQuotaInMb == 0 {
+		env.StorageQuotaInMb = 5 * 1024
+	}
 
 	return env, nil
 }
@@ -3,17 +3,29 @@ package conversation
 import (
 	"backend/apperror"
 	"backend/common/result"
+	"backend/config"
 	"backend/database/ent"
 	"backend/database/ent/conversation"
 	"backend/database/ent/conversationmember"
+	"backend/database/ent/draft"
 	"backend/database/ent/message"
+	"backend/database/ent/pinnedmessage"
+	entpredicate "backend/database/ent/predicate"
+	"backend/database/ent/schema/enum"
+	"backend/database/ent/schema/payload"
 	"backend/database/ent/user"
 	"backend/database/predicate"
 	"backend/file"
 	"backend/http/pagination"
+	"backend/user/setting"
 	"backend/websocket"
 	"context"
+	"encoding/json"
+	"path"
 	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
 
 	"entgo.io/ent/dialect/sql"
 	"github.com/cockroachdb/errors"
@@ -23,18 +35,21 @@ import (
 type Conversation struct {
 	file      file.File
 	websocket *websocket.Websocket
+	setting   *setting.Setting
 }
 
 type conversationParams struct {
 	fx.In
 	File      file.File
 	Websocket *websocket.Websocket
+	Setting   *setting.Setting
 }
 
 func newConversation(p conversationParams) *Conversation {
 	return &Conversation{
 		file:      p.File,
 		websocket: p.Websocket,
+		setting:   p.Setting,
 	}
 }
 
@@ -47,7 +62,23 @@ func (s *Conversation) GetOnlineUsers(ctx context.Context, client *ent.Client, p
 		return nil, errors.Wrap(err, "User.Query() failed")
 	}
 
-	return users, nil
+	err = s.setting.FilterUsers(ctx, client, &setting.FilterUsersParams{
+		ViewerId: p.UserId,
+		Users:    users,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Drop users hiding their online status from the caller
+	res := make([]*ent.User, 0, len(users))
+	for _, u := range users {
+		if u.IsActive {
+			res = append(res, u)
+		}
+	}
+
+	return res, nil
 }
 
 func (s *Conversation) Load(ctx context.Context, client *ent.Client, p *LoadParams) (*ent.Conversation, error) {
@@ -82,12 +113,15 @@ func (s *Conversation) Load(ctx context.Context, client *ent.Client, p *LoadPara
 	}
 
 	cMembers, err := client.ConversationMember.CreateBulk(
+		// Both sides of a 1:1 conversation have the same rights
 		client.ConversationMember.Create().
 			SetUserID(p.FromUserId).
-			SetConversationID(c.ID),
+			SetConversationID(c.ID).
+			SetRole(enum.ConversationRoleAdmin),
 		client.ConversationMember.Create().
 			SetUserID(p.ToUserId).
-			SetConversationID(c.ID),
+			SetConversationID(c.ID).
+			SetRole(enum.ConversationRoleAdmin),
 	).
 		Save(ctx)
 	if err != nil {
@@ -96,6 +130,13 @@ func (s *Conversation) Load(ctx context.Context, client *ent.Client, p *LoadPara
 
 	c.Edges.Members = cMembers
 
+	_, err = s.createSystemMessage(ctx, client, p.FromUserId, c.ID, &payload.SystemMessage{
+		Event: enum.SystemEventConversationCreated,
+	})
+	if err != nil {
+		return nil, err
+	}
+
 	return c, nil
 }
 
@@ -116,11 +157,12 @@ func (s *Conversation) Get(ctx context.Context, client *ent.Client, p *GetParams
 		Where(
 			conversation.HasMembersWith(
 				conversationmember.UserIdEQ(p.UserId),
+				conversationmember.IsArchived(p.Archived),
 			),
 		).
 		WithMembers(func(q *ent.ConversationMemberQuery) {
 			q.WithUser(func(q *ent.UserQuery) {
-				q.Select(user.FieldFullname, user.FieldEmail, user.FieldAvatar, user.FieldIsActive, user.FieldLastActiveAt)
+				q.Select(user.FieldFullname, user.FieldUsername, user.FieldEmail, user.FieldAvatar, user.FieldIsActive, user.FieldLastActiveAt)
 			})
 			q.Where(
 				conversationmember.HasUserWith(
@@ -138,10 +180,16 @@ func (s *Conversation) Get(ctx context.Context, client *ent.Client, p *GetParams
 							Comma().
 							Ident(s.C(message.FieldCreatedAt)).
 							Comma().
-							Ident(s.C(message.FieldContent)).
+							Ident(s.C(message.FieldPlainText)).
 							Comma().
 							Ident(s.C(message.FieldIsSeen)).
 							Comma().
+							Ident(s.C(message.FieldStatus)).
+							Comma().
+							Ident(s.C(message.FieldType)).
+							Comma().
+							Ident(s.C(message.FieldPayload)).
+							Comma().
 							Ident(s.C(message.FieldConversationId)).
 							Comma().
 							Ident(s.C(message.FieldUserId))
@@ -149,7 +197,7 @@ func (s *Conversation) Get(ctx context.Context, client *ent.Client, p *GetParams
 					}),
 				)
 				s.OrderBy(s.C(message.FieldConversationId), sql.Desc(s.C(message.FieldCreatedAt)))
-			}).WithMedia()
+			}).Where(notExpired()).WithMedia()
 		})
 	if p.Search != "" {
 		queryBuilder.Where(
@@ -177,9 +225,18 @@ func (s *Conversation) Get(ctx context.Context, client *ent.Client, p *GetParams
 		Limit(limit).
 		Offset(offset).
 		Order(func(s *sql.Selector) {
+			// Pinned conversations of the caller come first, in their pinned order
+			cm := sql.Table(conversationmember.Table).As("cm")
+			s.Join(cm).On(s.C(conversation.FieldID), cm.C(conversationmember.FieldConversationId))
+			s.Where(sql.EQ(cm.C(conversationmember.FieldUserId), p.UserId))
+			s.OrderExpr(sql.P(func(b *sql.Builder) {
+				b.Ident(cm.C(conversationmember.FieldPinnedOrder)).
+					WriteString(" ASC NULLS LAST")
+			}))
+
 			t := sql.Table(message.Table)
 			s.LeftJoin(t).On(s.C(conversation.FieldID), t.C(message.FieldConversationId))
-			s.GroupBy(s.C(conversation.FieldID))
+			s.GroupBy(s.C(conversation.FieldID), cm.C(conversationmember.FieldPinnedOrder))
 			s.OrderExpr(sql.P(func(b *sql.Builder) {
 				b.WriteString("MAX(").
 					Ident(t.C(message.FieldCreatedAt)).
@@ -192,6 +249,39 @@ func (s *Conversation) Get(ctx context.Context, client *ent.Client, p *GetParams
 		return nil, errors.Wrap(err, "All failed")
 	}
 
+	// ---- Apply privacy settings ----
+	var users []*ent.User
+	var memberIds []int
+	for _, c := range rows {
+		for _, m := range c.Edges.Members {
+			users = append(users, m.Edges.User)
+			memberIds = append(memberIds, m.UserId)
+		}
+	}
+	err = s.setting.FilterUsers(ctx, client, &setting.FilterUsersParams{
+		ViewerId: p.UserId,
+		Users:    users,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	readReceipts, err := s.setting.GetReadReceipts(ctx, client, memberIds)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range rows {
+		signMedia(s.file, c.Edges.Messages...)
+		if !hidesReadReceipts(readReceipts, c.Edges.Members) {
+			continue
+		}
+		for _, m := range c.Edges.Messages {
+			if m.UserId == p.UserId {
+				hideSeen(m)
+			}
+		}
+	}
+
 	// ---- Query unread counts ----
 	ids := make([]int, len(rows))
 	for i, c := range rows {
@@ -221,12 +311,44 @@ func (s *Conversation) Get(ctx context.Context, client *ent.Client, p *GetParams
 		unreadMap[ur.ConversationId] = ur.Count
 	}
 
+	// ---- Query the caller's own member state ----
+	ownMembers, err := client.ConversationMember.Query().
+		Where(
+			conversationmember.ConversationIdIn(ids...),
+			conversationmember.UserIdEQ(p.UserId),
+		).
+		All(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "Member query failed")
+	}
+	ownMemberMap := make(map[int]*ent.ConversationMember, len(ownMembers))
+	for _, m := range ownMembers {
+		ownMemberMap[m.ConversationId] = m
+	}
+
+	// ---- Query the caller's drafts ----
+	drafts, err := client.Draft.Query().
+		Where(
+			draft.ConversationIdIn(ids...),
+			draft.UserId(p.UserId),
+		).
+		All(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "Draft query failed")
+	}
+	draftMap := make(map[int]*ent.Draft, len(drafts))
+	for _, d := range drafts {
+		draftMap[d.ConversationId] = d
+	}
+
 	// ---- Map to DTO ----
 	res.Rows = make([]*ConversationResponse, len(rows))
 	for i, c := range rows {
 		cr := &ConversationResponse{
 			Conversation: c,
+			Member:       ownMemberMap[c.ID],
 			UnreadCount:  unreadMap[c.ID],
+			Draft:        draftMap[c.ID],
 		}
 
 		res.Rows[i] = cr
@@ -252,8 +374,8 @@ func (s *Conversation) Get(ctx context.Context, client *ent.Client, p *GetParams
 	return res, nil
 }
 
-func (s *Conversation) GetOne(ctx context.Context, client *ent.Client, p *GetOneParams) (*ent.Conversation, error) {
-	res, err := client.Conversation.
+func (s *Conversation) GetOne(ctx context.Context, client *ent.Client, p *GetOneParams) (*GetOneResult, error) {
+	c, err := client.Conversation.
 		Query().
 		Where(
 			conversation.ID(p.ConversationId),
@@ -263,7 +385,7 @@ func (s *Conversation) GetOne(ctx context.Context, client *ent.Client, p *GetOne
 		).
 		WithMembers(func(q *ent.ConversationMemberQuery) {
 			q.WithUser(func(q *ent.UserQuery) {
-				q.Select(user.FieldFullname, user.FieldEmail, user.FieldAvatar, user.FieldIsActive, user.FieldLastActiveAt)
+				q.Select(user.FieldFullname, user.FieldUsername, user.FieldEmail, user.FieldAvatar, user.FieldIsActive, user.FieldLastActiveAt)
 			})
 			q.Where(
 				conversationmember.HasUserWith(
@@ -275,11 +397,40 @@ func (s *Conversation) GetOne(ctx context.Context, client *ent.Client, p *GetOne
 	if err != nil && !ent.IsNotFound(err) {
 		return nil, errors.Wrap(err, "GetOne failed")
 	}
-	if res == nil {
+	if c == nil {
 		return nil, apperror.NotFound("Data not found", nil, nil)
 	}
 
-	return res, nil
+	users := make([]*ent.User, len(c.Edges.Members))
+	for i, m := range c.Edges.Members {
+		users[i] = m.Edges.User
+	}
+	err = s.setting.FilterUsers(ctx, client, &setting.FilterUsersParams{
+		ViewerId: p.UserId,
+		Users:    users,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	member, err := s.getMember(ctx, client, p.UserId, p.ConversationId)
+	if err != nil {
+		return nil, err
+	}
+
+	pinCount, err := client.PinnedMessage.
+		Query().
+		Where(pinnedmessage.ConversationId(p.ConversationId)).
+		Count(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "Pin count query failed")
+	}
+
+	return &GetOneResult{
+		Conversation: c,
+		Member:       member,
+		PinCount:     pinCount,
+	}, nil
 }
 
 func (s *Conversation) ValidateUserInConversation(ctx context.Context, client *ent.Client, p *ValidateUserInConversationParams) error {
@@ -319,6 +470,7 @@ func (s *Conversation) GetMessage(ctx context.Context, client *ent.Client, p *Ge
 			message.IsSeen(false),
 		).
 		SetIsSeen(true).
+		SetStatus(enum.MessageStatusRead).
 		Exec(ctx)
 	if err != nil {
 		return nil, errors.Wrap(err, "Update failed")
@@ -335,20 +487,41 @@ func (s *Conversation) GetMessage(ctx context.Context, client *ent.Client, p *Ge
 	if err != nil {
 		return nil, errors.Wrap(err, "Query failed")
 	}
-	for _, member := range members {
-		s.websocket.Clients().Group(
-			strconv.Itoa(member.UserId)).
-			Send(websocket.EventMessageSeen,
-				result.Success("", ""),
-			)
+
+	memberIds := make([]int, len(members))
+	for i, member := range members {
+		memberIds[i] = member.UserId
+	}
+	readReceipts, err := s.setting.GetReadReceipts(ctx, client, append(memberIds, p.UserId))
+	if err != nil {
+		return nil, err
+	}
+
+	if readReceipts[p.UserId] {
+		for _, member := range members {
+			s.websocket.Clients().Group(
+				strconv.Itoa(member.UserId)).
+				Send(websocket.EventMessageSeen,
+					result.Success("", ""),
+				)
+		}
 	}
 
 	queryBuilder := client.Message.
 		Query().
 		Where(
 			message.ConversationId(p.ConversationId),
+			notExpired(),
 		).
 		WithMedia().
+		WithMentions().
+		WithForwardedFrom(func(q *ent.UserQuery) {
+			q.Select(user.FieldFullname, user.FieldUsername, user.FieldAvatar)
+		}).
+		WithPoll(func(q *ent.PollQuery) {
+			withPollResults(q, p.UserId)
+		}).
+		WithLinkPreviews().
 		Order(ent.Desc(message.FieldCreatedAt))
 
 	res, err := pagination.Paginate(ctx, queryBuilder, &pagination.Query{
@@ -359,6 +532,16 @@ func (s *Conversation) GetMessage(ctx context.Context, client *ent.Client, p *Ge
 		return nil, errors.Wrap(err, "GetMessage failed")
 	}
 
+	signMedia(s.file, res.Rows...)
+
+	if hidesReadReceipts(readReceipts, members) {
+		for _, m := range res.Rows {
+			if m.UserId == p.UserId {
+				hideSeen(m)
+			}
+		}
+	}
+
 	return res, nil
 }
 
@@ -371,10 +554,30 @@ func (s *Conversation) CreateMessage(ctx context.Context, client *ent.Client, p
 		return nil, err
 	}
 
+	content, offsets := sanitizeContent(p.Content)
+	entities, err := validateEntities(offsets, p.Entities)
+	if err != nil {
+		return nil, err
+	}
+
+	expiresAt, err := s.messageExpiresAt(ctx, client, p.ConversationId)
+	if err != nil {
+		return nil, err
+	}
+
+	messageType := enum.MessageTypeText
+	if len(p.Media) > 0 {
+		messageType = enum.MessageTypeMedia
+	}
+
 	message, err := client.Message.Create().
 		SetUserID(p.UserId).
 		SetConversationID(p.ConversationId).
-		SetContent(p.Content).
+		SetType(messageType).
+		SetContent(content).
+		SetEntities(entities).
+		SetPlainText(plainText(content)).
+		SetNillableExpiresAt(expiresAt).
 		Save(ctx)
 	if err != nil {
 		return nil, errors.Wrap(err, "Message.Create failed")
@@ -382,13 +585,30 @@ func (s *Conversation) CreateMessage(ctx context.Context, client *ent.Client, p
 
 	var builders []*ent.MessageMediaCreate
 	for _, mediaData := range p.Media {
-		mediaSrc, err := s.file.MoveFromTemporary(mediaData.Src, file.FolderMessageMedia)
+		var mediaSrc string
+		if mediaData.Stored {
+			mediaSrc, err = s.file.Copy(ctx, client, mediaData.Src, file.FolderMessageMedia)
+		} else {
+			mediaSrc, err = s.file.MoveFromTemporary(ctx, client, mediaData.Src, file.FolderMessageMedia)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		metadata, err := s.file.Inspect(mediaSrc)
 		if err != nil {
 			return nil, err
 		}
 
 		builders = append(builders, client.MessageMedia.Create().
 			SetSrc(mediaSrc).
+			SetName(mediaName(mediaData.Name)).
+			SetMimeType(metadata.MimeType).
+			SetSize(metadata.Size).
+			SetNillableWidth(metadata.Width).
+			SetNillableHeight(metadata.Height).
+			SetNillableDuration(metadata.Duration).
+			SetVariants(metadata.Variants).
 			SetMessageID(message.ID))
 	}
 
@@ -398,6 +618,33 @@ func (s *Conversation) CreateMessage(ctx context.Context, client *ent.Client, p
 	}
 
 	message.Edges.Media = res
+	signMedia(s.file, message)
+
+	mentions, err := s.createMentions(ctx, client, &createMentionsParams{
+		UserId:         p.UserId,
+		ConversationId: p.ConversationId,
+		MessageId:      message.ID,
+		Content:        content,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	message.Edges.Mentions = mentions
+
+	if err := s.unarchiveOnNewMessage(ctx, client, p.ConversationId); err != nil {
+		return nil, err
+	}
+
+	if !p.KeepDraft {
+		err = s.ClearDraft(ctx, client, &ClearDraftParams{
+			UserId:         p.UserId,
+			ConversationId: p.ConversationId,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
 
 	// Send message to all members in conversation
 	members, err := client.ConversationMember.
@@ -418,19 +665,140 @@ func (s *Conversation) CreateMessage(ctx context.Context, client *ent.Client, p
 			)
 	}
 
+	// Notify mentioned members, once per member
+	mentionedUserIds := make(map[int]struct{}, len(mentions))
+	for _, m := range mentions {
+		if _, ok := mentionedUserIds[m.UserId]; ok {
+			continue
+		}
+		mentionedUserIds[m.UserId] = struct{}{}
+
+		s.websocket.Clients().Group(
+			strconv.Itoa(m.UserId)).
+			Send(websocket.EventMentioned,
+				result.Success("", message),
+			)
+	}
+
 	return message, nil
 }
 
+func (s *Conversation) createMentions(ctx context.Context, client *ent.Client, p *createMentionsParams) ([]*ent.MessageMention, error) {
+	mentions := parseMentions(p.Content)
+	if len(mentions) == 0 {
+		return nil, nil
+	}
+
+	// Only members of the conversation can be mentioned
+	users, err := client.User.
+		Query().
+		Where(
+			user.UsernameIn(mentionUsernames(mentions)...),
+			user.IDNEQ(p.UserId),
+			user.HasConversationMembersWith(
+				conversationmember.ConversationId(p.ConversationId),
+			),
+		).
+		Select(user.FieldUsername).
+		All(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "User.Query() failed")
+	}
+
+	userIds := make(map[string]int, len(users))
+	for _, u := range users {
+		userIds[*u.Username] = u.ID
+	}
+
+	var builders []*ent.MessageMentionCreate
+	for _, m := range mentions {
+		userId, ok := userIds[m.username]
+		if !ok {
+			continue
+		}
+
+		builders = append(builders, client.MessageMention.Create().
+			SetOffset(m.offset).
+			SetLength(m.length).
+			SetMessageID(p.MessageId).
+			SetUserID(userId))
+	}
+
+	res, err := client.MessageMention.CreateBulk(builders...).Save(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "MessageMention.CreateBulk failed")
+	}
+
+	return res, nil
+}
+
+// notExpired hides messages whose disappearing timer has run out but which the
+// reaper has not deleted yet.
+func notExpired() entpredicate.Message {
+	return message.Or(
+		message.ExpiresAtIsNil(),
+		message.ExpiresAtGT(time.Now()),
+	)
+}
+
+// signMedia replaces the media paths of messages with signed ones, so only
+// the members the messages are returned to can download them.
+func signMedia(f file.File, messages ...*ent.Message) {
+	for _, m := range messages {
+		if m == nil {
+			continue
+		}
+		for _, media := range m.Edges.Media {
+			media.Src = f.Sign(media.Src, media.Name)
+		}
+	}
+}
+
+// mediaName keeps the base of a client provided file name, bounded in length.
+func mediaName(name string) string {
+	name = strings.TrimSpace(path.Base(strings.ReplaceAll(name, "\\", "/")))
+	if name == "." || name == "/" {
+		return ""
+	}
+	if utf8.RuneCountInString(name) > config.MaxMediaNameLength {
+		name = string([]rune(name)[:config.MaxMediaNameLength])
+	}
+	return name
+}
+
+// hideSeen masks the read state of a message, it still shows as delivered.
+func hideSeen(m *ent.Message) {
+	m.IsSeen = false
+	if m.Status == enum.MessageStatusRead {
+		m.Status = enum.MessageStatusDelivered
+	}
+}
+
+// hidesReadReceipts reports whether none of the given members sends read
+// receipts, in which case the seen state of the caller's messages is hidden.
+func hidesReadReceipts(readReceipts map[int]bool, members []*ent.ConversationMember) bool {
+	if len(members) == 0 {
+		return false
+	}
+	for _, m := range members {
+		if readReceipts[m.UserId] {
+			return false
+		}
+	}
+	return true
+}
+
 type LoadParams struct {
 	FromUserId int
 	ToUserId   int
 }
 
 type GetParams struct {
-	UserId int
-	Limit  int
-	Page   int
-	Search string
+	UserId   int
+	Limit    int
+	Page     int
+	Search   string
+	Archived bool
 }
 
 type GetResult struct {
@@ -442,8 +810,35 @@ type GetResult struct {
 }
 
 type ConversationResponse struct {
-	Conversation *ent.Conversation `json:"conversation"`
-	UnreadCount  int               `json:"unreadCount"`
+	Conversation *ent.Conversation       `json:"conversation"`
+	Member       *ent.ConversationMember `json:"member"`
+	UnreadCount  int                     `json:"unreadCount"`
+	Draft        *ent.Draft              `json:"draft"`
+}
+
+// GetOneResult is a conversation with the caller's member state and its pin
+// count alongside its own fields.
+type GetOneResult struct {
+	Conversation *ent.Conversation
+	Member       *ent.ConversationMember
+	PinCount     int
+}
+
+// MarshalJSON implements the json.Marshaler interface, inlining the
+// conversation like ent does for its edges.
+func (r *GetOneResult) MarshalJSON() ([]byte, error) {
+	type Alias ent.Conversation
+	return json.Marshal(&struct {
+		*Alias
+		ent.ConversationEdges
+		Member   *ent.ConversationMember `json:"member"`
+		PinCount int                     `json:"pinCount"`
+	}{
+		Alias:             (*Alias)(r.Conversation),
+		ConversationEdges: r.Conversation.Edges,
+		Member:            r.Member,
+		PinCount:          r.PinCount,
+	})
 }
 
 type GetOneParams struct {
@@ -467,11 +862,27 @@ type CreateMessageParams struct {
 	UserId         int
 	ConversationId int
 	Content        string
+	Entities       []*payload.MessageEntity
 	Media          []*CreateMedia
+	// KeepDraft leaves the sender's draft alone, for messages not typed in
+	// the composer such as scheduled ones.
+	KeepDraft bool
 }
 
 type CreateMedia struct {
 	Src string
+	// Name is the original file name, shown to recipients
+	Name string
+	// Stored marks Src as the path of an already stored file, which is copied
+	// instead of being moved out of the temporary folder.
+	Stored bool
+}
+
+type createMentionsParams struct {
+	UserId         int
+	ConversationId int
+	MessageId      int
+	Content        string
 }
 
 type GetOnlineUsersParams struct {
@@ -0,0 +1,183 @@
+package conversation
+
+import (
+	"backend/apperror"
+	"backend/common/result"
+	"backend/database/ent"
+	"backend/database/ent/draft"
+	"backend/database/ent/message"
+	"backend/database/ent/temporaryfile"
+	"backend/websocket"
+	"context"
+	"strconv"
+
+	"github.com/cockroachdb/errors"
+)
+
+// SaveDraft creates or replaces the caller's draft in the conversation. An
+// empty draft is cleared instead of being stored. Media of a draft left
+// untouched for config.DraftMediaExpiresInDay is dropped by the janitor.
+func (s *Conversation) SaveDraft(ctx context.Context, client *ent.Client, p *SaveDraftParams) (*ent.Draft, error) {
+	err := s.ValidateUserInConversation(ctx, client, &ValidateUserInConversationParams{
+		UserId:         p.UserId,
+		ConversationId: p.ConversationId,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if p.Content == "" && p.ReplyToMessageId == nil && len(p.Media) == 0 {
+		return nil, s.ClearDraft(ctx, client, &ClearDraftParams{
+			UserId:         p.UserId,
+			ConversationId: p.ConversationId,
+		})
+	}
+
+	if p.ReplyToMessageId != nil {
+		exists, err := client.Message.
+			Query().
+			Where(
+				message.ID(*p.ReplyToMessageId),
+				message.ConversationId(p.ConversationId),
+				notExpired(),
+			).
+			Exist(ctx)
+		if err != nil {
+			return nil, errors.Wrap(err, "Query failed")
+		}
+		if !exists {
+			return nil, apperror.NotFound("Message not found", nil, nil)
+		}
+	}
+
+	if len(p.Media) > 0 {
+		// Only files the user uploaded and that are still in the temporary
+		// folder can be kept in a draft
+		names := map[string]bool{}
+		for _, name := range p.Media {
+			names[name] = true
+		}
+		count, err := client.TemporaryFile.
+			Query().
+			Where(
+				temporaryfile.NameIn(p.Media...),
+				temporaryfile.UserId(p.UserId),
+			).
+			Count(ctx)
+		if err != nil {
+			return nil, errors.Wrap(err, "Query failed")
+		}
+		if count != len(names) {
+			return nil, apperror.NotFound("Media not found", nil, nil)
+		}
+	}
+
+	id, err := client.Draft.Create().
+		SetUserID(p.UserId).
+		SetConversationID(p.ConversationId).
+		SetContent(p.Content).
+		SetNillableReplyToMessageId(p.ReplyToMessageId).
+		SetMedia(p.Media).
+		OnConflictColumns(draft.FieldConversationId, draft.FieldUserId).
+		Update(func(u *ent.DraftUpsert) {
+			u.UpdateContent().
+				UpdateReplyToMessageId().
+				UpdateMedia().
+				UpdateUpdatedAt()
+		}).
+		ID(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "Draft.Save failed")
+	}
+
+	res, err := client.Draft.Get(ctx, id)
+	if err != nil {
+		return nil, errors.Wrap(err, "Query failed")
+	}
+
+	s.sendDraftUpdated(p.UserId, p.ConversationId, res)
+
+	return res, nil
+}
+
+func (s *Conversation) GetDraft(ctx context.Context, client *ent.Client, p *GetDraftParams) (*ent.Draft, error) {
+	err := s.ValidateUserInConversation(ctx, client, &ValidateUserInConversationParams{
+		UserId:         p.UserId,
+		ConversationId: p.ConversationId,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return s.getDraft(ctx, client, p.UserId, p.ConversationId)
+}
+
+func (s *Conversation) ClearDraft(ctx context.Context, client *ent.Client, p *ClearDraftParams) error {
+	count, err := client.Draft.
+		Delete().
+		Where(
+			draft.UserId(p.UserId),
+			draft.ConversationId(p.ConversationId),
+		).
+		Exec(ctx)
+	if err != nil {
+		return errors.Wrap(err, "Draft.Delete failed")
+	}
+
+	if count > 0 {
+		s.sendDraftUpdated(p.UserId, p.ConversationId, nil)
+	}
+
+	return nil
+}
+
+func (s *Conversation) getDraft(ctx context.Context, client *ent.Client, userId, conversationId int) (*ent.Draft, error) {
+	res, err := client.Draft.
+		Query().
+		Where(
+			draft.UserId(userId),
+			draft.ConversationId(conversationId),
+		).
+		First(ctx)
+	if err != nil && !ent.IsNotFound(err) {
+		return nil, errors.Wrap(err, "Query failed")
+	}
+
+	return res, nil
+}
+
+// sendDraftUpdated syncs the draft to every device of the user, a nil draft
+// means it was cleared.
+func (s *Conversation) sendDraftUpdated(userId, conversationId int, d *ent.Draft) {
+	s.websocket.Clients().Group(
+		strconv.Itoa(userId)).
+		Send(websocket.EventDraftUpdated,
+			result.Success("", &DraftUpdatedEvent{
+				ConversationId: conversationId,
+				Draft:          d,
+			}),
+		)
+}
+
+type SaveDraftParams struct {
+	UserId           int
+	ConversationId   int
+	Content          string
+	ReplyToMessageId *int
+	Media            []string
+}
+
+type GetDraftParams struct {
+	UserId         int
+	ConversationId int
+}
+
+type ClearDraftParams struct {
+	UserId         int
+	ConversationId int
+}
+
+type DraftUpdatedEvent struct {
+	ConversationId int        `json:"conversationId"`
+	Draft          *ent.Draft `json:"draft"`
+}
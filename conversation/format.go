@@ -0,0 +1,132 @@
+package conversation
+
+import (
+	"backend/apperror"
+	"backend/database/ent/schema/enum"
+	"backend/database/ent/schema/payload"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+const maxMessageEntities = 100
+
+var linkSchemes = []string{"http", "https", "mailto"}
+
+// sanitizeContent drops invalid UTF-8, control characters other than newlines
+// and tabs (so "\r\n" becomes "\n"), and bidirectional overrides that can
+// disguise text or links.
+//
+// Offsets into content are counted in UTF-16 code units, like string indices
+// in JavaScript clients. offsets maps each offset into content, up to its
+// length, to the matching offset into res. Offsets falling inside a surrogate
+// pair map to -1.
+func sanitizeContent(content string) (res string, offsets []int) {
+	var b strings.Builder
+	offsets = make([]int, 0, len(content)+1)
+	n := 0
+	for i := 0; i < len(content); {
+		r, size := utf8.DecodeRuneInString(content[i:])
+		i += size
+
+		offsets = append(offsets, n)
+		units := utf16.RuneLen(r)
+		for j := 1; j < units; j++ {
+			offsets = append(offsets, -1)
+		}
+
+		switch {
+		case r == utf8.RuneError && size == 1:
+		case r != '\n' && r != '\t' && (unicode.IsControl(r) || isBidiControl(r)):
+		default:
+			b.WriteRune(r)
+			n += units
+		}
+	}
+	offsets = append(offsets, n)
+
+	return b.String(), offsets
+}
+
+// utf16Length is the length of s in UTF-16 code units.
+func utf16Length(s string) int {
+	n := 0
+	for _, r := range s {
+		n += utf16.RuneLen(r)
+	}
+	return n
+}
+
+func isBidiControl(r rune) bool {
+	return (r >= '\u202A' && r <= '\u202E') || (r >= '\u2066' && r <= '\u2069')
+}
+
+// plainText collapses whitespace so the content fits a single line preview.
+func plainText(content string) string {
+	return strings.Join(strings.Fields(content), " ")
+}
+
+// validateEntities checks that every entity fits inside content as sent, has
+// a known type and a safe url, and that entities are either nested or
+// disjoint. offsets is the mapping returned by sanitizeContent, the entities
+// are returned moved along to the sanitized content. Entities left empty, that
+// only covered dropped characters, are left out.
+func validateEntities(offsets []int, entities []*payload.MessageEntity) ([]*payload.MessageEntity, error) {
+	if len(entities) > maxMessageEntities {
+		return nil, apperror.BadRequest(fmt.Sprintf("A message can have at most %d formatting entities", maxMessageEntities), nil, nil)
+	}
+
+	contentLength := len(offsets) - 1
+	for _, e := range entities {
+		if !slices.Contains(enum.MessageEntityType("").Values(), string(e.Type)) {
+			return nil, apperror.BadRequest("Unknown formatting entity type", e, nil)
+		}
+		if e.Offset < 0 || e.Length <= 0 || e.Offset+e.Length > contentLength {
+			return nil, apperror.BadRequest("Formatting entity is out of the content range", e, nil)
+		}
+		if offsets[e.Offset] < 0 || offsets[e.Offset+e.Length] < 0 {
+			return nil, apperror.BadRequest("Formatting entity must not split a character", e, nil)
+		}
+
+		if e.Type != enum.MessageEntityTypeLink {
+			e.URL = ""
+			continue
+		}
+		u, err := url.Parse(e.URL)
+		if err != nil || !slices.Contains(linkSchemes, strings.ToLower(u.Scheme)) {
+			return nil, apperror.BadRequest("Link must be an http, https or mailto url", e, nil)
+		}
+	}
+
+	for i, a := range entities {
+		for _, b := range entities[i+1:] {
+			aEnd, bEnd := a.Offset+a.Length, b.Offset+b.Length
+			disjoint := aEnd <= b.Offset || bEnd <= a.Offset
+			nested := (a.Offset <= b.Offset && bEnd <= aEnd) || (b.Offset <= a.Offset && aEnd <= bEnd)
+			if !disjoint && !nested {
+				return nil, apperror.BadRequest("Formatting entities must not partially overlap", nil, nil)
+			}
+		}
+	}
+
+	// Offsets only move back, so nested and disjoint entities stay so
+	res := make([]*payload.MessageEntity, 0, len(entities))
+	for _, e := range entities {
+		start, end := offsets[e.Offset], offsets[e.Offset+e.Length]
+		if start == end {
+			continue
+		}
+		res = append(res, &payload.MessageEntity{
+			Type:   e.Type,
+			Offset: start,
+			Length: end - start,
+			URL:    e.URL,
+		})
+	}
+
+	return res, nil
+}
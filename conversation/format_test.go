@@ -0,0 +1,130 @@
+package conversation
+
+import (
+	"backend/apperror"
+	"backend/database/ent/schema/enum"
+	"backend/database/ent/schema/payload"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/cockroachdb/errors"
+)
+
+func TestSanitizeContent(t *testing.T) {
+	for _, c := range []struct {
+		name    string
+		content string
+		res     string
+		offsets []int
+	}{
+		{"plain", "abc", "abc", []int{0, 1, 2, 3}},
+		{"crlf", "a\r\nb", "a\nb", []int{0, 1, 1, 2, 3}},
+		{"tab", "a\tb", "a\tb", []int{0, 1, 2, 3}},
+		{"surrogate pair", "😀a", "😀a", []int{0, -1, 2, 3}},
+		{"bidi override", "a\u202eb\u2066c", "abc", []int{0, 1, 1, 2, 2, 3}},
+		{"invalid utf-8", "a\xffb", "ab", []int{0, 1, 1, 2}},
+		{"dropped before surrogate pair", "\x00😀", "😀", []int{0, 0, -1, 2}},
+		{"empty", "", "", []int{0}},
+	} {
+		res, offsets := sanitizeContent(c.content)
+		if res != c.res || !slices.Equal(offsets, c.offsets) {
+			t.Errorf("%s: got %q %v, want %q %v", c.name, res, offsets, c.res, c.offsets)
+		}
+	}
+}
+
+func TestValidateEntities(t *testing.T) {
+	bold := func(offset, length int) *payload.MessageEntity {
+		return &payload.MessageEntity{Type: enum.MessageEntityTypeBold, Offset: offset, Length: length}
+	}
+	italic := func(offset, length int) *payload.MessageEntity {
+		return &payload.MessageEntity{Type: enum.MessageEntityTypeItalic, Offset: offset, Length: length}
+	}
+	link := func(offset, length int, url string) *payload.MessageEntity {
+		return &payload.MessageEntity{Type: enum.MessageEntityTypeLink, Offset: offset, Length: length, URL: url}
+	}
+
+	for _, c := range []struct {
+		name     string
+		content  string
+		entities []*payload.MessageEntity
+		want     []*payload.MessageEntity
+		err      string
+	}{
+		// Surrogate pairs count as two units
+		{"emoji", "😀 hi", []*payload.MessageEntity{bold(0, 2), italic(3, 2)}, []*payload.MessageEntity{bold(0, 2), italic(3, 2)}, ""},
+		{"emoji end", "hi 😀", []*payload.MessageEntity{bold(3, 2)}, []*payload.MessageEntity{bold(3, 2)}, ""},
+		{"split start", "😀 hi", []*payload.MessageEntity{bold(1, 2)}, nil, "Formatting entity must not split a character"},
+		{"split end", "😀 hi", []*payload.MessageEntity{bold(0, 1)}, nil, "Formatting entity must not split a character"},
+		{"past emoji", "😀", []*payload.MessageEntity{bold(0, 3)}, nil, "Formatting entity is out of the content range"},
+
+		// Dropped characters move the entities after them back
+		{"crlf", "a\r\nbold", []*payload.MessageEntity{bold(3, 4)}, []*payload.MessageEntity{bold(2, 4)}, ""},
+		{"bidi before", "\u202ebold", []*payload.MessageEntity{bold(1, 4)}, []*payload.MessageEntity{bold(0, 4)}, ""},
+		{"bidi inside", "bo\u202eld", []*payload.MessageEntity{bold(0, 5)}, []*payload.MessageEntity{bold(0, 4)}, ""},
+		{"only dropped", "a\u202eb", []*payload.MessageEntity{bold(1, 1), italic(0, 3)}, []*payload.MessageEntity{italic(0, 2)}, ""},
+		{"range of content as sent", "\x00\x00ab", []*payload.MessageEntity{bold(2, 2)}, []*payload.MessageEntity{bold(0, 2)}, ""},
+
+		// Entities are nested or disjoint
+		{"nested", "hello world", []*payload.MessageEntity{bold(0, 11), italic(6, 5)}, []*payload.MessageEntity{bold(0, 11), italic(6, 5)}, ""},
+		{"same range", "hello", []*payload.MessageEntity{bold(0, 5), italic(0, 5)}, []*payload.MessageEntity{bold(0, 5), italic(0, 5)}, ""},
+		{"disjoint", "hello world", []*payload.MessageEntity{bold(0, 5), italic(5, 6)}, []*payload.MessageEntity{bold(0, 5), italic(5, 6)}, ""},
+		{"partial overlap", "hello world", []*payload.MessageEntity{bold(0, 5), italic(3, 5)}, nil, "Formatting entities must not partially overlap"},
+		{"nested after drop", "a\u202ebc", []*payload.MessageEntity{bold(0, 4), italic(2, 2)}, []*payload.MessageEntity{bold(0, 3), italic(1, 2)}, ""},
+
+		// Links
+		{"https", "site", []*payload.MessageEntity{link(0, 4, "https://example.com")}, []*payload.MessageEntity{link(0, 4, "https://example.com")}, ""},
+		{"mailto", "mail", []*payload.MessageEntity{link(0, 4, "mailto:a@example.com")}, []*payload.MessageEntity{link(0, 4, "mailto:a@example.com")}, ""},
+		{"javascript", "click", []*payload.MessageEntity{link(0, 5, "javascript:alert(1)")}, nil, "Link must be an http, https or mailto url"},
+		{"javascript upper case", "click", []*payload.MessageEntity{link(0, 5, "JavaScript:alert(1)")}, nil, "Link must be an http, https or mailto url"},
+		{"javascript padded", "click", []*payload.MessageEntity{link(0, 5, " javascript:alert(1)")}, nil, "Link must be an http, https or mailto url"},
+		{"data", "click", []*payload.MessageEntity{link(0, 5, "data:text/html,<script>alert(1)</script>")}, nil, "Link must be an http, https or mailto url"},
+		{"relative", "click", []*payload.MessageEntity{link(0, 5, "/path")}, nil, "Link must be an http, https or mailto url"},
+		{"url dropped off other types", "bold", []*payload.MessageEntity{{Type: enum.MessageEntityTypeBold, Length: 4, URL: "javascript:alert(1)"}}, []*payload.MessageEntity{bold(0, 4)}, ""},
+
+		// Ranges and types
+		{"negative offset", "hello", []*payload.MessageEntity{bold(-1, 2)}, nil, "Formatting entity is out of the content range"},
+		{"empty", "hello", []*payload.MessageEntity{bold(1, 0)}, nil, "Formatting entity is out of the content range"},
+		{"past end", "hello", []*payload.MessageEntity{bold(3, 3)}, nil, "Formatting entity is out of the content range"},
+		{"unknown type", "hello", []*payload.MessageEntity{{Type: "spoiler", Length: 5}}, nil, "Unknown formatting entity type"},
+	} {
+		_, offsets := sanitizeContent(c.content)
+		got, err := validateEntities(offsets, c.entities)
+
+		if c.err != "" {
+			var appErr *apperror.AppError
+			if !errors.As(err, &appErr) || appErr.Message != c.err {
+				t.Errorf("%s: got %v, want %q", c.name, err, c.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		if !slices.EqualFunc(got, c.want, func(a, b *payload.MessageEntity) bool { return *a == *b }) {
+			t.Errorf("%s: got %v, want %v", c.name, entityValues(got), entityValues(c.want))
+		}
+	}
+}
+
+func TestValidateEntitiesLimit(t *testing.T) {
+	entities := make([]*payload.MessageEntity, maxMessageEntities+1)
+	for i := range entities {
+		entities[i] = &payload.MessageEntity{Type: enum.MessageEntityTypeBold, Offset: i, Length: 1}
+	}
+
+	_, offsets := sanitizeContent(strings.Repeat("a", maxMessageEntities+1))
+	if _, err := validateEntities(offsets, entities); err == nil {
+		t.Fatal("entities over the limit accepted")
+	}
+}
+
+func entityValues(entities []*payload.MessageEntity) []payload.MessageEntity {
+	res := make([]payload.MessageEntity, len(entities))
+	for i, e := range entities {
+		res[i] = *e
+	}
+	return res
+}
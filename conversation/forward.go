@@ -0,0 +1,157 @@
+package conversation
+
+import (
+	"backend/apperror"
+	"backend/database/ent"
+	"backend/database/ent/conversation"
+	"backend/database/ent/conversationmember"
+	"backend/database/ent/message"
+	"backend/database/ent/schema/enum"
+	"backend/file"
+	"backend/websocket"
+	"context"
+
+	"github.com/cockroachdb/errors"
+)
+
+// ForwardMessage copies messages the caller can read into every target
+// conversation the caller belongs to. Media files are duplicated so deleting
+// the original message does not break the forwarded copy.
+func (s *Conversation) ForwardMessage(ctx context.Context, client *ent.Client, p *ForwardMessageParams) ([]*ent.Message, error) {
+	sources, err := client.Message.
+		Query().
+		Where(
+			message.IDIn(p.MessageIds...),
+			// Polls belong to the conversation they were asked in
+			message.TypeNotIn(enum.MessageTypeSystem, enum.MessageTypePoll),
+			message.HasConversationWith(
+				conversation.HasMembersWith(
+					conversationmember.UserIdEQ(p.UserId),
+				),
+			),
+		).
+		WithMedia().
+		WithLinkPreviews().
+		Order(ent.Asc(message.FieldCreatedAt), ent.Asc(message.FieldID)).
+		All(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "Query failed")
+	}
+	if len(sources) != len(uniqueInts(p.MessageIds)) {
+		return nil, apperror.NotFound("Message not found", nil, nil)
+	}
+
+	var res []*ent.Message
+	for _, conversationId := range uniqueInts(p.ConversationIds) {
+		err := s.ValidateUserInConversation(ctx, client, &ValidateUserInConversationParams{
+			UserId:         p.UserId,
+			ConversationId: conversationId,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, source := range sources {
+			forwarded, err := s.forwardOne(ctx, client, p.UserId, conversationId, source)
+			if err != nil {
+				return nil, err
+			}
+
+			res = append(res, forwarded)
+		}
+
+		if err := s.unarchiveOnNewMessage(ctx, client, conversationId); err != nil {
+			return nil, err
+		}
+	}
+
+	return res, nil
+}
+
+func (s *Conversation) forwardOne(ctx context.Context, client *ent.Client, userId, conversationId int, source *ent.Message) (*ent.Message, error) {
+	// Keep the original origin when forwarding a forwarded message
+	forwardedFromUserId := source.UserId
+	if source.ForwardedFromUserId != nil {
+		forwardedFromUserId = *source.ForwardedFromUserId
+	}
+	forwardedFromConversationId := source.ConversationId
+	if source.ForwardedFromConversationId != nil {
+		forwardedFromConversationId = *source.ForwardedFromConversationId
+	}
+
+	expiresAt, err := s.messageExpiresAt(ctx, client, conversationId)
+	if err != nil {
+		return nil, err
+	}
+
+	message, err := client.Message.Create().
+		SetUserID(userId).
+		SetConversationID(conversationId).
+		SetType(source.Type).
+		SetContent(source.Content).
+		SetEntities(source.Entities).
+		SetPlainText(source.PlainText).
+		SetNillableExpiresAt(expiresAt).
+		SetForwardedFromUserId(forwardedFromUserId).
+		SetForwardedFromConversationId(forwardedFromConversationId).
+		// Previews are shared by url, the copy reuses them instead of fetching again
+		AddLinkPreviews(source.Edges.LinkPreviews...).
+		Save(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "Message.Create failed")
+	}
+
+	var builders []*ent.MessageMediaCreate
+	for _, media := range source.Edges.Media {
+		mediaSrc, err := s.file.Copy(ctx, client, media.Src, file.FolderMessageMedia)
+		if err != nil {
+			return nil, err
+		}
+
+		// The copy has the same content, and so the same metadata and variants
+		builders = append(builders, client.MessageMedia.Create().
+			SetSrc(mediaSrc).
+			SetName(media.Name).
+			SetMimeType(media.MimeType).
+			SetSize(media.Size).
+			SetNillableWidth(media.Width).
+			SetNillableHeight(media.Height).
+			SetNillableDuration(media.Duration).
+			SetVariants(media.Variants).
+			SetMessageID(message.ID))
+	}
+
+	media, err := client.MessageMedia.CreateBulk(builders...).Save(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "CreateBulk failed")
+	}
+
+	message.Edges.Media = media
+	signMedia(s.file, message)
+	message.Edges.LinkPreviews = source.Edges.LinkPreviews
+
+	if err := s.sendToOtherMembers(ctx, client, userId, conversationId, websocket.EventMessageReceived, message); err != nil {
+		return nil, err
+	}
+
+	return message, nil
+}
+
+func uniqueInts(values []int) []int {
+	seen := make(map[int]struct{}, len(values))
+	res := make([]int, 0, len(values))
+	for _, v := range values {
+		if _, ok := seen[v]; ok {
+			continue
+		}
+		seen[v] = struct{}{}
+		res = append(res, v)
+	}
+	return res
+}
+
+type ForwardMessageParams struct {
+	UserId          int
+	MessageIds      []int
+	ConversationIds []int
+}
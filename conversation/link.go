@@ -0,0 +1,39 @@
+package conversation
+
+import (
+	"backend/config"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+const maxLinkLength = 2048
+
+var linkPattern = regexp.MustCompile(`https?://[^\s<>"]+`)
+
+// parseLinks returns the distinct http(s) urls in content, in order of
+// appearance, up to the per message preview limit. Trailing punctuation is
+// treated as part of the sentence, not the url.
+func parseLinks(content string) []string {
+	var links []string
+	seen := make(map[string]struct{})
+	for _, match := range linkPattern.FindAllString(content, -1) {
+		link := strings.TrimRight(match, ".,;:!?)]}'")
+		if len(link) > maxLinkLength {
+			continue
+		}
+		if u, err := url.Parse(link); err != nil || u.Hostname() == "" {
+			continue
+		}
+		if _, ok := seen[link]; ok {
+			continue
+		}
+		seen[link] = struct{}{}
+
+		links = append(links, link)
+		if len(links) == config.MaxLinkPreviewsPerMessage {
+			break
+		}
+	}
+	return links
+}
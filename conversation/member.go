@@ -0,0 +1,229 @@
+package conversation
+
+import (
+	"backend/apperror"
+	"backend/config"
+	"backend/database/ent"
+	"backend/database/ent/conversationmember"
+	"backend/database/ent/schema/enum"
+	"context"
+	"slices"
+	"time"
+
+	"github.com/cockroachdb/errors"
+)
+
+func (s *Conversation) getMember(ctx context.Context, client *ent.Client, userId, conversationId int) (*ent.ConversationMember, error) {
+	member, err := client.ConversationMember.
+		Query().
+		Where(
+			conversationmember.ConversationId(conversationId),
+			conversationmember.UserIdEQ(userId),
+		).
+		First(ctx)
+	if err != nil && !ent.IsNotFound(err) {
+		return nil, errors.Wrap(err, "Query failed")
+	}
+	if member == nil {
+		return nil, apperror.BadRequest("You are not in the conversation", nil, nil)
+	}
+
+	return member, nil
+}
+
+// requireRole loads the caller's membership and checks it has one of the
+// roles. Both sides of a 1:1 conversation have the same rights, whatever their
+// role, since members of conversations created before roles existed have none.
+func (s *Conversation) requireRole(ctx context.Context, client *ent.Client, userId, conversationId int, roles ...enum.ConversationRole) (*ent.ConversationMember, error) {
+	member, err := s.getMember(ctx, client, userId, conversationId)
+	if err != nil {
+		return nil, err
+	}
+	if slices.Contains(roles, member.Role) {
+		return member, nil
+	}
+
+	count, err := client.ConversationMember.
+		Query().
+		Where(conversationmember.ConversationId(conversationId)).
+		Count(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "Query failed")
+	}
+	if count > 2 {
+		return nil, apperror.Forbidden("You do not have permission to do this in the conversation", nil, nil)
+	}
+
+	return member, nil
+}
+
+func (s *Conversation) Mute(ctx context.Context, client *ent.Client, p *MuteParams) (*ent.ConversationMember, error) {
+	if p.MutedUntil != nil && !p.MutedUntil.After(time.Now()) {
+		return nil, apperror.BadRequest("Mute expiry must be in the future", nil, nil)
+	}
+
+	member, err := s.getMember(ctx, client, p.UserId, p.ConversationId)
+	if err != nil {
+		return nil, err
+	}
+
+	updateBuilder := member.Update()
+	if p.MutedUntil != nil {
+		updateBuilder.SetMutedUntil(*p.MutedUntil)
+	} else {
+		updateBuilder.ClearMutedUntil()
+	}
+
+	member, err = updateBuilder.Save(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "ConversationMember.Update() failed")
+	}
+
+	return member, nil
+}
+
+func (s *Conversation) Pin(ctx context.Context, client *ent.Client, p *PinParams) (*ent.ConversationMember, error) {
+	member, err := s.getMember(ctx, client, p.UserId, p.ConversationId)
+	if err != nil {
+		return nil, err
+	}
+
+	if !p.Pinned {
+		member, err = member.Update().ClearPinnedOrder().Save(ctx)
+		if err != nil {
+			return nil, errors.Wrap(err, "ConversationMember.Update() failed")
+		}
+		return member, nil
+	}
+
+	if member.PinnedOrder != nil {
+		return member, nil
+	}
+
+	pinned, err := client.ConversationMember.
+		Query().
+		Where(
+			conversationmember.UserIdEQ(p.UserId),
+			conversationmember.PinnedOrderNotNil(),
+		).
+		Order(ent.Desc(conversationmember.FieldPinnedOrder)).
+		All(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "Query failed")
+	}
+	if len(pinned) >= config.MaxPinnedConversations {
+		return nil, apperror.BadRequest(
+			errors.Newf("You can pin at most %d conversations", config.MaxPinnedConversations).Error(),
+			nil, nil,
+		)
+	}
+
+	// New pins go after the existing ones
+	order := 0
+	if len(pinned) > 0 {
+		order = *pinned[0].PinnedOrder + 1
+	}
+
+	member, err = member.Update().SetPinnedOrder(order).Save(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "ConversationMember.Update() failed")
+	}
+
+	return member, nil
+}
+
+func (s *Conversation) ReorderPins(ctx context.Context, client *ent.Client, p *ReorderPinsParams) ([]*ent.ConversationMember, error) {
+	pinned, err := client.ConversationMember.
+		Query().
+		Where(
+			conversationmember.UserIdEQ(p.UserId),
+			conversationmember.PinnedOrderNotNil(),
+		).
+		All(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "Query failed")
+	}
+
+	pinnedMap := make(map[int]*ent.ConversationMember, len(pinned))
+	for _, member := range pinned {
+		pinnedMap[member.ConversationId] = member
+	}
+	if len(p.ConversationIds) != len(pinned) {
+		return nil, apperror.BadRequest("Conversation ids must list every pinned conversation", nil, nil)
+	}
+
+	res := make([]*ent.ConversationMember, len(p.ConversationIds))
+	for i, conversationId := range p.ConversationIds {
+		member, ok := pinnedMap[conversationId]
+		if !ok {
+			return nil, apperror.BadRequest("Conversation ids must list every pinned conversation", nil, nil)
+		}
+		delete(pinnedMap, conversationId)
+
+		res[i], err = member.Update().SetPinnedOrder(i).Save(ctx)
+		if err != nil {
+			return nil, errors.Wrap(err, "ConversationMember.Update() failed")
+		}
+	}
+
+	return res, nil
+}
+
+func (s *Conversation) Archive(ctx context.Context, client *ent.Client, p *ArchiveParams) (*ent.ConversationMember, error) {
+	member, err := s.getMember(ctx, client, p.UserId, p.ConversationId)
+	if err != nil {
+		return nil, err
+	}
+
+	member, err = member.Update().SetIsArchived(p.Archived).Save(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "ConversationMember.Update() failed")
+	}
+
+	return member, nil
+}
+
+// unarchiveOnNewMessage brings archived conversations back to the list of
+// every member who has not muted it.
+func (s *Conversation) unarchiveOnNewMessage(ctx context.Context, client *ent.Client, conversationId int) error {
+	err := client.ConversationMember.
+		Update().
+		Where(
+			conversationmember.ConversationId(conversationId),
+			conversationmember.IsArchived(true),
+			conversationmember.Or(
+				conversationmember.MutedUntilIsNil(),
+				conversationmember.MutedUntilLTE(time.Now()),
+			),
+		).
+		SetIsArchived(false).
+		Exec(ctx)
+	if err != nil {
+		return errors.Wrap(err, "ConversationMember.Update() failed")
+	}
+
+	return nil
+}
+
+type MuteParams struct {
+	UserId         int
+	ConversationId int
+	MutedUntil     *time.Time
+}
+
+type PinParams struct {
+	UserId         int
+	ConversationId int
+	Pinned         bool
+}
+
+type ReorderPinsParams struct {
+	UserId          int
+	ConversationIds []int
+}
+
+type ArchiveParams struct {
+	UserId         int
+	ConversationId int
+	Archived       bool
+}
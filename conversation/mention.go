@@ -0,0 +1,73 @@
+package conversation
+
+import (
+	"backend/common"
+	"unicode"
+	"unicode/utf16"
+)
+
+type mention struct {
+	username string
+	offset   int
+	length   int
+}
+
+// parseMentions finds @username tokens in content. An '@' only starts a
+// mention at the beginning of the text or after a character that cannot be
+// part of a username, so emails like "a@b.com" are not treated as mentions.
+// Offsets are counted in UTF-16 code units, like formatting entities.
+func parseMentions(content string) []mention {
+	var mentions []mention
+	runes := []rune(content)
+
+	// offsets[i] is the UTF-16 offset of runes[i]
+	offsets := make([]int, len(runes))
+	for i := 1; i < len(runes); i++ {
+		offsets[i] = offsets[i-1] + utf16.RuneLen(runes[i-1])
+	}
+
+	for i := 0; i < len(runes); i++ {
+		if runes[i] != '@' || (i > 0 && (common.IsUsernameRune(runes[i-1]) || runes[i-1] == '@')) {
+			continue
+		}
+
+		end := i + 1
+		for end < len(runes) && common.IsUsernameRune(runes[end]) {
+			end++
+		}
+		// Usernames are ASCII only, "@nguyễn" must not resolve to "@nguy".
+		if end < len(runes) && (unicode.IsLetter(runes[end]) || unicode.IsDigit(runes[end])) {
+			i = end
+			continue
+		}
+		// A trailing dot is punctuation, not part of the username.
+		for end > i+1 && runes[end-1] == '.' {
+			end--
+		}
+
+		username := common.NormalizeUsername(string(runes[i+1 : end]))
+		if common.ValidateUsername(username) == nil {
+			mentions = append(mentions, mention{
+				username: username,
+				offset:   offsets[i],
+				length:   utf16Length(username) + 1,
+			})
+		}
+		i = end - 1
+	}
+
+	return mentions
+}
+
+func mentionUsernames(mentions []mention) []string {
+	seen := make(map[string]struct{}, len(mentions))
+	var usernames []string
+	for _, m := range mentions {
+		if _, ok := seen[m.username]; ok {
+			continue
+		}
+		seen[m.username] = struct{}{}
+		usernames = append(usernames, m.username)
+	}
+	return usernames
+}
@@ -3,5 +3,6 @@ package conversation
 import "go.uber.org/fx"
 
 var Module = fx.Module("conversation",
-	fx.Provide(newConversation, newRouter),
+	fx.Provide(newConversation, newRouter, newScheduler, newReaper, newUnfurler),
+	fx.Invoke(func(*Scheduler, *Reaper) {}),
 )
@@ -0,0 +1,176 @@
+package conversation
+
+import (
+	"backend/apperror"
+	"backend/common/result"
+	"backend/database/ent"
+	"backend/database/ent/conversationmember"
+	"backend/database/ent/message"
+	"backend/database/ent/pinnedmessage"
+	"backend/database/ent/schema/enum"
+	"backend/database/ent/user"
+	"backend/http/pagination"
+	"backend/websocket"
+	"context"
+	"strconv"
+
+	"github.com/cockroachdb/errors"
+)
+
+func (s *Conversation) PinMessage(ctx context.Context, client *ent.Client, p *PinMessageParams) (*ent.PinnedMessage, error) {
+	_, err := s.requireRole(ctx, client, p.UserId, p.ConversationId, enum.ConversationRoleOwner, enum.ConversationRoleAdmin)
+	if err != nil {
+		return nil, err
+	}
+
+	exists, err := client.Message.
+		Query().
+		Where(
+			message.ID(p.MessageId),
+			message.ConversationId(p.ConversationId),
+		).
+		Exist(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "Query failed")
+	}
+	if !exists {
+		return nil, apperror.NotFound("Message not found", nil, nil)
+	}
+
+	pin, err := client.PinnedMessage.
+		Query().
+		Where(pinnedmessage.MessageId(p.MessageId)).
+		First(ctx)
+	if err != nil && !ent.IsNotFound(err) {
+		return nil, errors.Wrap(err, "Query failed")
+	}
+	if pin != nil {
+		return pin, nil
+	}
+
+	pin, err = client.PinnedMessage.Create().
+		SetConversationID(p.ConversationId).
+		SetMessageID(p.MessageId).
+		SetUserID(p.UserId).
+		Save(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "PinnedMessage.Create failed")
+	}
+
+	pin.Edges.Message, err = client.Message.
+		Query().
+		Where(message.ID(p.MessageId)).
+		WithMedia().
+		Only(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "Query failed")
+	}
+	signMedia(s.file, pin.Edges.Message)
+
+	if err := s.sendToOtherMembers(ctx, client, p.UserId, p.ConversationId, websocket.EventMessagePinned, pin); err != nil {
+		return nil, err
+	}
+
+	return pin, nil
+}
+
+func (s *Conversation) UnpinMessage(ctx context.Context, client *ent.Client, p *PinMessageParams) error {
+	_, err := s.requireRole(ctx, client, p.UserId, p.ConversationId, enum.ConversationRoleOwner, enum.ConversationRoleAdmin)
+	if err != nil {
+		return err
+	}
+
+	pin, err := client.PinnedMessage.
+		Query().
+		Where(
+			pinnedmessage.MessageId(p.MessageId),
+			pinnedmessage.ConversationId(p.ConversationId),
+		).
+		First(ctx)
+	if err != nil && !ent.IsNotFound(err) {
+		return errors.Wrap(err, "Query failed")
+	}
+	if pin == nil {
+		return apperror.NotFound("Pinned message not found", nil, nil)
+	}
+
+	if err := client.PinnedMessage.DeleteOne(pin).Exec(ctx); err != nil {
+		return errors.Wrap(err, "PinnedMessage.Delete failed")
+	}
+
+	return s.sendToOtherMembers(ctx, client, p.UserId, p.ConversationId, websocket.EventMessageUnpinned, pin)
+}
+
+func (s *Conversation) GetPins(ctx context.Context, client *ent.Client, p *GetPinsParams) (*pagination.Result[*ent.PinnedMessage], error) {
+	err := s.ValidateUserInConversation(ctx, client, &ValidateUserInConversationParams{
+		UserId:         p.UserId,
+		ConversationId: p.ConversationId,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	queryBuilder := client.PinnedMessage.
+		Query().
+		Where(
+			pinnedmessage.ConversationId(p.ConversationId),
+		).
+		WithMessage(func(q *ent.MessageQuery) {
+			q.WithMedia()
+		}).
+		WithUser(func(q *ent.UserQuery) {
+			q.Select(user.FieldFullname, user.FieldUsername, user.FieldAvatar)
+		}).
+		Order(ent.Desc(pinnedmessage.FieldCreatedAt))
+
+	res, err := pagination.Paginate(ctx, queryBuilder, &pagination.Query{
+		Limit: p.Limit,
+		Page:  p.Page,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "GetPins failed")
+	}
+
+	for _, pin := range res.Rows {
+		signMedia(s.file, pin.Edges.Message)
+	}
+
+	return res, nil
+}
+
+// sendToOtherMembers sends a hub event to every member of the conversation
+// except the caller.
+func (s *Conversation) sendToOtherMembers(ctx context.Context, client *ent.Client, userId, conversationId int, event string, data any) error {
+	members, err := client.ConversationMember.
+		Query().
+		Where(
+			conversationmember.ConversationId(conversationId),
+			conversationmember.UserIdNEQ(userId),
+		).
+		All(ctx)
+	if err != nil {
+		return errors.Wrap(err, "Query failed")
+	}
+	for _, member := range members {
+		s.websocket.Clients().Group(
+			strconv.Itoa(member.UserId)).
+			Send(event,
+				result.Success("", data),
+			)
+	}
+
+	return nil
+}
+
+type PinMessageParams struct {
+	UserId         int
+	ConversationId int
+	MessageId      int
+}
+
+type GetPinsParams struct {
+	UserId         int
+	ConversationId int
+	Limit          int
+	Page           int
+}
@@ -0,0 +1,280 @@
+package conversation
+
+import (
+	"backend/apperror"
+	"backend/config"
+	"backend/database/ent"
+	"backend/database/ent/message"
+	"backend/database/ent/poll"
+	"backend/database/ent/polloption"
+	"backend/database/ent/pollvote"
+	"backend/database/ent/schema/enum"
+	"backend/database/ent/user"
+	"backend/websocket"
+	"context"
+	"fmt"
+	"time"
+
+	"entgo.io/ent/dialect/sql"
+	"github.com/cockroachdb/errors"
+)
+
+func (s *Conversation) CreatePoll(ctx context.Context, client *ent.Client, p *CreatePollParams) (*ent.Message, error) {
+	err := s.ValidateUserInConversation(ctx, client, &ValidateUserInConversationParams{
+		UserId:         p.UserId,
+		ConversationId: p.ConversationId,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(p.Options) < 2 || len(p.Options) > config.MaxPollOptions {
+		return nil, apperror.BadRequest(fmt.Sprintf("A poll must have between 2 and %d options", config.MaxPollOptions), nil, nil)
+	}
+	if p.ClosesAt != nil && !p.ClosesAt.After(time.Now()) {
+		return nil, apperror.BadRequest("Poll close time must be in the future", nil, nil)
+	}
+
+	expiresAt, err := s.messageExpiresAt(ctx, client, p.ConversationId)
+	if err != nil {
+		return nil, err
+	}
+
+	// The question doubles as the message content so previews stay readable
+	message, err := client.Message.Create().
+		SetUserID(p.UserId).
+		SetConversationID(p.ConversationId).
+		SetType(enum.MessageTypePoll).
+		SetContent(p.Question).
+		SetPlainText(plainText(p.Question)).
+		SetNillableExpiresAt(expiresAt).
+		Save(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "Message.Create failed")
+	}
+
+	pollEntity, err := client.Poll.Create().
+		SetQuestion(p.Question).
+		SetIsMultipleChoice(p.IsMultipleChoice).
+		SetIsAnonymous(p.IsAnonymous).
+		SetNillableClosesAt(p.ClosesAt).
+		SetMessageID(message.ID).
+		Save(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "Poll.Create failed")
+	}
+
+	builders := make([]*ent.PollOptionCreate, len(p.Options))
+	for i, text := range p.Options {
+		builders[i] = client.PollOption.Create().
+			SetText(text).
+			SetPosition(i).
+			SetPollID(pollEntity.ID)
+	}
+	options, err := client.PollOption.CreateBulk(builders...).Save(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "PollOption.CreateBulk failed")
+	}
+
+	pollEntity.Edges.Options = options
+	message.Edges.Poll = pollEntity
+
+	if err := s.unarchiveOnNewMessage(ctx, client, p.ConversationId); err != nil {
+		return nil, err
+	}
+
+	if err := s.sendToOtherMembers(ctx, client, p.UserId, p.ConversationId, websocket.EventMessageReceived, message); err != nil {
+		return nil, err
+	}
+
+	return message, nil
+}
+
+// VotePoll replaces the caller's votes on the poll with the given options. An
+// empty list retracts the vote.
+func (s *Conversation) VotePoll(ctx context.Context, client *ent.Client, p *VotePollParams) (*ent.Poll, error) {
+	err := s.ValidateUserInConversation(ctx, client, &ValidateUserInConversationParams{
+		UserId:         p.UserId,
+		ConversationId: p.ConversationId,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Lock the poll so concurrent votes do not miscount
+	queryBuilder := client.Poll.
+		Query().
+		Where(
+			poll.MessageId(p.MessageId),
+			poll.HasMessageWith(
+				message.ConversationId(p.ConversationId),
+				notExpired(),
+			),
+		)
+	queryBuilder.Modify(func(s *sql.Selector) {
+		s.ForUpdate()
+	})
+
+	pollEntity, err := queryBuilder.First(ctx)
+	if err != nil && !ent.IsNotFound(err) {
+		return nil, errors.Wrap(err, "Query failed")
+	}
+	if pollEntity == nil {
+		return nil, apperror.NotFound("Poll not found", nil, nil)
+	}
+
+	if pollEntity.ClosesAt != nil && !time.Now().Before(*pollEntity.ClosesAt) {
+		return nil, apperror.BadRequest("Poll is closed", nil, nil)
+	}
+
+	optionIds := uniqueInts(p.OptionIds)
+	if !pollEntity.IsMultipleChoice && len(optionIds) > 1 {
+		return nil, apperror.BadRequest("Only one option can be chosen in this poll", nil, nil)
+	}
+
+	count, err := client.PollOption.
+		Query().
+		Where(
+			polloption.IDIn(optionIds...),
+			polloption.PollId(pollEntity.ID),
+		).
+		Count(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "Count failed")
+	}
+	if count != len(optionIds) {
+		return nil, apperror.NotFound("Poll option not found", nil, nil)
+	}
+
+	_, err = client.PollVote.
+		Delete().
+		Where(
+			pollvote.PollId(pollEntity.ID),
+			pollvote.UserId(p.UserId),
+		).
+		Exec(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "PollVote.Delete failed")
+	}
+
+	builders := make([]*ent.PollVoteCreate, len(optionIds))
+	for i, optionId := range optionIds {
+		builders[i] = client.PollVote.Create().
+			SetPollID(pollEntity.ID).
+			SetOptionID(optionId).
+			SetUserID(p.UserId)
+	}
+	if _, err := client.PollVote.CreateBulk(builders...).Save(ctx); err != nil {
+		return nil, errors.Wrap(err, "PollVote.CreateBulk failed")
+	}
+
+	if err := s.recountPoll(ctx, client, pollEntity.ID); err != nil {
+		return nil, err
+	}
+
+	// Other members get the shared results, the caller gets their own votes too
+	shared, err := s.getPollResults(ctx, client, pollEntity.ID, 0)
+	if err != nil {
+		return nil, err
+	}
+	err = s.sendToOtherMembers(ctx, client, p.UserId, p.ConversationId, websocket.EventPollUpdated, &PollUpdatedEvent{
+		ConversationId: p.ConversationId,
+		MessageId:      p.MessageId,
+		Poll:           shared,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return s.getPollResults(ctx, client, pollEntity.ID, p.UserId)
+}
+
+func (s *Conversation) recountPoll(ctx context.Context, client *ent.Client, pollId int) error {
+	var rows []struct {
+		OptionId int `json:"option_id"`
+		Count    int `json:"count"`
+	}
+	err := client.PollVote.
+		Query().
+		Where(pollvote.PollId(pollId)).
+		GroupBy(pollvote.FieldOptionId).
+		Aggregate(ent.Count()).
+		Scan(ctx, &rows)
+	if err != nil {
+		return errors.Wrap(err, "Vote count query failed")
+	}
+
+	counts := make(map[int]int, len(rows))
+	for _, row := range rows {
+		counts[row.OptionId] = row.Count
+	}
+
+	options, err := client.PollOption.Query().Where(polloption.PollId(pollId)).All(ctx)
+	if err != nil {
+		return errors.Wrap(err, "PollOption.Query failed")
+	}
+	for _, option := range options {
+		if option.VoteCount == counts[option.ID] {
+			continue
+		}
+		if err := option.Update().SetVoteCount(counts[option.ID]).Exec(ctx); err != nil {
+			return errors.Wrap(err, "PollOption.Update failed")
+		}
+	}
+
+	return nil
+}
+
+func (s *Conversation) getPollResults(ctx context.Context, client *ent.Client, pollId, viewerId int) (*ent.Poll, error) {
+	queryBuilder := client.Poll.Query().Where(poll.ID(pollId))
+	withPollResults(queryBuilder, viewerId)
+
+	res, err := queryBuilder.Only(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "Poll.Query failed")
+	}
+
+	return res, nil
+}
+
+// withPollResults loads the options with their vote counts and the votes the
+// viewer may see: every vote of a public poll, only their own otherwise.
+func withPollResults(q *ent.PollQuery, viewerId int) {
+	q.WithOptions(func(q *ent.PollOptionQuery) {
+		q.Order(ent.Asc(polloption.FieldPosition))
+	})
+	q.WithVotes(func(q *ent.PollVoteQuery) {
+		q.Where(
+			pollvote.Or(
+				pollvote.UserId(viewerId),
+				pollvote.HasPollWith(poll.IsAnonymous(false)),
+			),
+		)
+		q.WithUser(func(q *ent.UserQuery) {
+			q.Select(user.FieldFullname, user.FieldUsername, user.FieldAvatar)
+		})
+	})
+}
+
+type CreatePollParams struct {
+	UserId           int
+	ConversationId   int
+	Question         string
+	Options          []string
+	IsMultipleChoice bool
+	IsAnonymous      bool
+	ClosesAt         *time.Time
+}
+
+type VotePollParams struct {
+	UserId         int
+	ConversationId int
+	MessageId      int
+	OptionIds      []int
+}
+
+type PollUpdatedEvent struct {
+	ConversationId int       `json:"conversationId"`
+	MessageId      int       `json:"messageId"`
+	Poll           *ent.Poll `json:"poll"`
+}
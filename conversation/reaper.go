@@ -0,0 +1,209 @@
+package conversation
+
+import (
+	"backend/common/result"
+	"backend/config"
+	"backend/database"
+	"backend/database/ent"
+	"backend/database/ent/conversationmember"
+	"backend/database/ent/message"
+	"backend/database/ent/messagedelivery"
+	"backend/database/ent/messagemedia"
+	"backend/database/ent/messagemention"
+	"backend/database/ent/pinnedmessage"
+	"backend/database/ent/poll"
+	"backend/database/ent/polloption"
+	"backend/database/ent/pollvote"
+	"backend/logger"
+	"backend/websocket"
+	"context"
+	"strconv"
+	"time"
+
+	"entgo.io/ent/dialect/sql"
+	"github.com/cockroachdb/errors"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+)
+
+// Reaper hard deletes messages whose disappearing timer has run out, together
+// with their media files, and tells open clients to remove them.
+type Reaper struct {
+	client       *ent.Client
+	conversation *Conversation
+	logger       *logger.Logger
+}
+
+type reaperParams struct {
+	fx.In
+	fx.Lifecycle
+	Client       *ent.Client
+	Conversation *Conversation
+	Logger       *logger.Logger
+}
+
+func newReaper(p reaperParams) *Reaper {
+	r := &Reaper{
+		client:       p.Client,
+		conversation: p.Conversation,
+		logger:       p.Logger,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	p.Lifecycle.Append(fx.Hook{
+		OnStart: func(context.Context) error {
+			go func() {
+				defer close(done)
+				r.run(ctx)
+			}()
+			return nil
+		},
+		OnStop: func(stopCtx context.Context) error {
+			cancel()
+			select {
+			case <-done:
+			case <-stopCtx.Done():
+			}
+			return nil
+		},
+	})
+
+	return r
+}
+
+func (r *Reaper) run(ctx context.Context) {
+	ticker := time.NewTicker(config.ReaperIntervalInSecond * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.reap(ctx)
+		}
+	}
+}
+
+func (r *Reaper) reap(ctx context.Context) {
+	for ctx.Err() == nil {
+		count, err := r.reapBatch(ctx)
+		if err != nil {
+			r.logger.Error("Reap expired messages failed", zap.Error(err))
+			return
+		}
+		if count < config.ReaperBatchSize {
+			return
+		}
+	}
+}
+
+func (r *Reaper) reapBatch(ctx context.Context) (int, error) {
+	var expired []*ent.Message
+	err := database.WithTx(ctx, r.client, func(tx *ent.Tx) error {
+		queryBuilder := tx.Message.
+			Query().
+			Where(message.ExpiresAtLTE(time.Now())).
+			Order(ent.Asc(message.FieldExpiresAt)).
+			Limit(config.ReaperBatchSize).
+			WithMedia()
+		queryBuilder.Modify(func(s *sql.Selector) {
+			s.ForUpdate(sql.WithLockAction(sql.SkipLocked))
+		})
+
+		var err error
+		expired, err = queryBuilder.All(ctx)
+		if err != nil {
+			return errors.Wrap(err, "Query failed")
+		}
+		if len(expired) == 0 {
+			return nil
+		}
+
+		ids := make([]int, len(expired))
+		for i, m := range expired {
+			ids[i] = m.ID
+		}
+
+		for _, m := range expired {
+			for _, media := range m.Edges.Media {
+				if err := r.conversation.file.Delete(ctx, tx.Client(), media.Src); err != nil {
+					return err
+				}
+			}
+		}
+		if _, err := tx.MessageMedia.Delete().Where(messagemedia.MessageIdIn(ids...)).Exec(ctx); err != nil {
+			return errors.Wrap(err, "MessageMedia.Delete failed")
+		}
+		if _, err := tx.MessageMention.Delete().Where(messagemention.MessageIdIn(ids...)).Exec(ctx); err != nil {
+			return errors.Wrap(err, "MessageMention.Delete failed")
+		}
+		if _, err := tx.PinnedMessage.Delete().Where(pinnedmessage.MessageIdIn(ids...)).Exec(ctx); err != nil {
+			return errors.Wrap(err, "PinnedMessage.Delete failed")
+		}
+		if _, err := tx.MessageDelivery.Delete().Where(messagedelivery.MessageIdIn(ids...)).Exec(ctx); err != nil {
+			return errors.Wrap(err, "MessageDelivery.Delete failed")
+		}
+		if _, err := tx.PollVote.Delete().Where(pollvote.HasPollWith(poll.MessageIdIn(ids...))).Exec(ctx); err != nil {
+			return errors.Wrap(err, "PollVote.Delete failed")
+		}
+		if _, err := tx.PollOption.Delete().Where(polloption.HasPollWith(poll.MessageIdIn(ids...))).Exec(ctx); err != nil {
+			return errors.Wrap(err, "PollOption.Delete failed")
+		}
+		if _, err := tx.Poll.Delete().Where(poll.MessageIdIn(ids...)).Exec(ctx); err != nil {
+			return errors.Wrap(err, "Poll.Delete failed")
+		}
+		if _, err := tx.Message.Delete().Where(message.IDIn(ids...)).Exec(ctx); err != nil {
+			return errors.Wrap(err, "Message.Delete failed")
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	deleted := make(map[int][]int)
+	for _, m := range expired {
+		deleted[m.ConversationId] = append(deleted[m.ConversationId], m.ID)
+	}
+
+	for conversationId, messageIds := range deleted {
+		if err := r.notifyDeleted(ctx, conversationId, messageIds); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(expired), nil
+}
+
+func (r *Reaper) notifyDeleted(ctx context.Context, conversationId int, messageIds []int) error {
+	members, err := r.client.ConversationMember.
+		Query().
+		Where(conversationmember.ConversationId(conversationId)).
+		All(ctx)
+	if err != nil {
+		return errors.Wrap(err, "Query failed")
+	}
+
+	data := &MessageDeletedEvent{
+		ConversationId: conversationId,
+		MessageIds:     messageIds,
+	}
+	for _, member := range members {
+		r.conversation.websocket.Clients().Group(
+			strconv.Itoa(member.UserId)).
+			Send(websocket.EventMessageDeleted,
+				result.Success("", data),
+			)
+	}
+
+	return nil
+}
+
+type MessageDeletedEvent struct {
+	ConversationId int   `json:"conversationId"`
+	MessageIds     []int `json:"messageIds"`
+}
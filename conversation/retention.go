@@ -0,0 +1,110 @@
+package conversation
+
+import (
+	"backend/database/ent"
+	"backend/database/ent/conversation"
+	"backend/database/ent/schema/enum"
+	"backend/database/ent/schema/payload"
+	"backend/websocket"
+	"context"
+	"time"
+
+	"github.com/cockroachdb/errors"
+)
+
+// SetMessageRetention changes how long new messages of the conversation are
+// kept and announces the change with a system message. Messages sent before
+// the change keep their expiry.
+func (s *Conversation) SetMessageRetention(ctx context.Context, client *ent.Client, p *SetMessageRetentionParams) (*ent.Conversation, error) {
+	err := s.ValidateUserInConversation(ctx, client, &ValidateUserInConversationParams{
+		UserId:         p.UserId,
+		ConversationId: p.ConversationId,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := client.Conversation.Query().Where(conversation.ID(p.ConversationId)).First(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "Conversation.Query() failed")
+	}
+	if c.MessageRetention == p.Retention {
+		return c, nil
+	}
+
+	c, err = c.Update().SetMessageRetention(p.Retention).Save(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "Conversation.Update() failed")
+	}
+
+	message, err := s.createSystemMessage(ctx, client, p.UserId, p.ConversationId, &payload.SystemMessage{
+		Event:     enum.SystemEventMessageRetentionChanged,
+		Retention: p.Retention,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	c.Edges.Messages = []*ent.Message{message}
+
+	return c, nil
+}
+
+// createSystemMessage stores a server generated message and delivers it to
+// the other members like any other message.
+func (s *Conversation) createSystemMessage(ctx context.Context, client *ent.Client, userId, conversationId int, data *payload.SystemMessage) (*ent.Message, error) {
+	expiresAt, err := s.messageExpiresAt(ctx, client, conversationId)
+	if err != nil {
+		return nil, err
+	}
+
+	message, err := client.Message.Create().
+		SetUserID(userId).
+		SetConversationID(conversationId).
+		SetType(enum.MessageTypeSystem).
+		SetPayload(data).
+		SetNillableExpiresAt(expiresAt).
+		Save(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "Message.Create failed")
+	}
+
+	if err := s.sendToOtherMembers(ctx, client, userId, conversationId, websocket.EventMessageReceived, message); err != nil {
+		return nil, err
+	}
+
+	return message, nil
+}
+
+// messageExpiresAt returns when a message sent now to the conversation
+// expires, or nil when disappearing messages are off.
+func (s *Conversation) messageExpiresAt(ctx context.Context, client *ent.Client, conversationId int) (*time.Time, error) {
+	c, err := client.Conversation.
+		Query().
+		Where(conversation.ID(conversationId)).
+		Select(conversation.FieldMessageRetention).
+		First(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "Conversation.Query() failed")
+	}
+
+	retention, ok := retentionDurations[c.MessageRetention]
+	if !ok {
+		return nil, nil
+	}
+
+	expiresAt := time.Now().Add(retention)
+	return &expiresAt, nil
+}
+
+var retentionDurations = map[enum.MessageRetention]time.Duration{
+	enum.MessageRetentionOneDay:  24 * time.Hour,
+	enum.MessageRetentionOneWeek: 7 * 24 * time.Hour,
+	enum.MessageRetentionMonth:   30 * 24 * time.Hour,
+}
+
+type SetMessageRetentionParams struct {
+	UserId         int
+	ConversationId int
+	Retention      enum.MessageRetention
+}
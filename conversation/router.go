@@ -4,10 +4,13 @@ import (
 	"backend/common/result"
 	"backend/database"
 	"backend/database/ent"
+	"backend/database/ent/schema/enum"
+	"backend/database/ent/schema/payload"
 	"backend/http/pagination"
 	"backend/http/validation"
 	"backend/security/auth"
 	"backend/security/jwt"
+	"time"
 
 	"github.com/kataras/iris/v12"
 	"github.com/kataras/iris/v12/core/router"
@@ -17,18 +20,21 @@ import (
 type Router struct {
 	client       *ent.Client
 	conversation *Conversation
+	unfurler     *Unfurler
 }
 
 type routerParams struct {
 	fx.In
 	Client       *ent.Client
 	Conversation *Conversation
+	Unfurler     *Unfurler
 }
 
 func newRouter(p routerParams) *Router {
 	return &Router{
 		client:       p.Client,
 		conversation: p.Conversation,
+		unfurler:     p.Unfurler,
 	}
 }
 
@@ -80,10 +86,95 @@ func (r *Router) Register(routerGroup router.Party) {
 				query := ctx.Values().Get(string(validation.ReadQuery)).(*getQuery)
 				claims := ctx.Values().Get(auth.KeyUserClaims).(*jwt.UserClaims)
 				res, err := r.conversation.Get(ctx, r.client, &GetParams{
-					UserId: claims.UserId,
-					Limit:  query.Limit,
-					Page:   query.Page,
-					Search: query.Search,
+					UserId:   claims.UserId,
+					Limit:    query.Limit,
+					Page:     query.Page,
+					Search:   query.Search,
+					Archived: query.Archived,
+				})
+
+				if err != nil {
+					ctx.SetErr(err)
+					return
+				}
+
+				ctx.JSON(result.Success("", res))
+			})
+
+			requireUserRouter.Post("/message/forward", validation.Validate[forwardMessageBody](validation.ReadBody), func(ctx iris.Context) {
+				body := ctx.Values().Get(string(validation.ReadBody)).(*forwardMessageBody)
+				claims := ctx.Values().Get(auth.KeyUserClaims).(*jwt.UserClaims)
+				err := database.WithTx(ctx, r.client, func(tx *ent.Tx) error {
+					res, err := r.conversation.ForwardMessage(ctx, tx.Client(), &ForwardMessageParams{
+						UserId:          claims.UserId,
+						MessageIds:      body.MessageIds,
+						ConversationIds: body.ConversationIds,
+					})
+
+					if err != nil {
+						return err
+					}
+
+					ctx.JSON(result.Success("Forward message success", res))
+					return nil
+				})
+
+				if err != nil {
+					ctx.SetErr(err)
+					return
+				}
+			})
+
+			requireUserRouter.Put("/pin-order", validation.Validate[reorderPinsBody](validation.ReadBody), func(ctx iris.Context) {
+				body := ctx.Values().Get(string(validation.ReadBody)).(*reorderPinsBody)
+				claims := ctx.Values().Get(auth.KeyUserClaims).(*jwt.UserClaims)
+				err := database.WithTx(ctx, r.client, func(tx *ent.Tx) error {
+					res, err := r.conversation.ReorderPins(ctx, tx.Client(), &ReorderPinsParams{
+						UserId:          claims.UserId,
+						ConversationIds: body.ConversationIds,
+					})
+
+					if err != nil {
+						return err
+					}
+
+					ctx.JSON(result.Success("", res))
+					return nil
+				})
+
+				if err != nil {
+					ctx.SetErr(err)
+					return
+				}
+			})
+
+			requireUserRouter.Put("/{conversationId}/mute",
+				validation.Validate[memberStateParams](validation.ReadParams),
+				validation.Validate[muteBody](validation.ReadBody),
+				func(ctx iris.Context) {
+					params := ctx.Values().Get(string(validation.ReadParams)).(*memberStateParams)
+					body := ctx.Values().Get(string(validation.ReadBody)).(*muteBody)
+					claims := ctx.Values().Get(auth.KeyUserClaims).(*jwt.UserClaims)
+					res, err := r.conversation.Mute(ctx, r.client, &MuteParams{
+						UserId:         claims.UserId,
+						ConversationId: params.ConversationId,
+						MutedUntil:     &body.MutedUntil,
+					})
+
+					if err != nil {
+						ctx.SetErr(err)
+						return
+					}
+
+					ctx.JSON(result.Success("", res))
+				})
+
+			requireUserRouter.Delete("/{conversationId}/mute", validation.Validate[memberStateParams](validation.ReadParams), func(ctx iris.Context) {
+				params := ctx.Values().Get(string(validation.ReadParams)).(*memberStateParams)
+				claims := ctx.Values().Get(auth.KeyUserClaims).(*jwt.UserClaims)
+				res, err := r.conversation.Mute(ctx, r.client, &MuteParams{
+					UserId:         claims.UserId,
+					ConversationId: params.ConversationId,
 				})
 
 				if err != nil {
@@ -94,6 +185,102 @@ func (r *Router) Register(routerGroup router.Party) {
 				ctx.JSON(result.Success("", res))
 			})
 
+			requireUserRouter.Put("/{conversationId}/pin", validation.Validate[memberStateParams](validation.ReadParams), func(ctx iris.Context) {
+				params := ctx.Values().Get(string(validation.ReadParams)).(*memberStateParams)
+				claims := ctx.Values().Get(auth.KeyUserClaims).(*jwt.UserClaims)
+				res, err := r.conversation.Pin(ctx, r.client, &PinParams{
+					UserId:         claims.UserId,
+					ConversationId: params.ConversationId,
+					Pinned:         true,
+				})
+
+				if err != nil {
+					ctx.SetErr(err)
+					return
+				}
+
+				ctx.JSON(result.Success("", res))
+			})
+
+			requireUserRouter.Delete("/{conversationId}/pin", validation.Validate[memberStateParams](validation.ReadParams), func(ctx iris.Context) {
+				params := ctx.Values().Get(string(validation.ReadParams)).(*memberStateParams)
+				claims := ctx.Values().Get(auth.KeyUserClaims).(*jwt.UserClaims)
+				res, err := r.conversation.Pin(ctx, r.client, &PinParams{
+					UserId:         claims.UserId,
+					ConversationId: params.ConversationId,
+					Pinned:         false,
+				})
+
+				if err != nil {
+					ctx.SetErr(err)
+					return
+				}
+
+				ctx.JSON(result.Success("", res))
+			})
+
+			requireUserRouter.Put("/{conversationId}/archive", validation.Validate[memberStateParams](validation.ReadParams), func(ctx iris.Context) {
+				params := ctx.Values().Get(string(validation.ReadParams)).(*memberStateParams)
+				claims := ctx.Values().Get(auth.KeyUserClaims).(*jwt.UserClaims)
+				res, err := r.conversation.Archive(ctx, r.client, &ArchiveParams{
+					UserId:         claims.UserId,
+					ConversationId: params.ConversationId,
+					Archived:       true,
+				})
+
+				if err != nil {
+					ctx.SetErr(err)
+					return
+				}
+
+				ctx.JSON(result.Success("", res))
+			})
+
+			requireUserRouter.Delete("/{conversationId}/archive", validation.Validate[memberStateParams](validation.ReadParams), func(ctx iris.Context) {
+				params := ctx.Values().Get(string(validation.ReadParams)).(*memberStateParams)
+				claims := ctx.Values().Get(auth.KeyUserClaims).(*jwt.UserClaims)
+				res, err := r.conversation.Archive(ctx, r.client, &ArchiveParams{
+					UserId:         claims.UserId,
+					ConversationId: params.ConversationId,
+					Archived:       false,
+				})
+
+				if err != nil {
+					ctx.SetErr(err)
+					return
+				}
+
+				ctx.JSON(result.Success("", res))
+			})
+
+			requireUserRouter.Put("/{conversationId}/message-retention",
+				validation.Validate[messageRetentionParams](validation.ReadParams),
+				validation.Validate[messageRetentionBody](validation.ReadBody),
+				func(ctx iris.Context) {
+					params := ctx.Values().Get(string(validation.ReadParams)).(*messageRetentionParams)
+					body := ctx.Values().Get(string(validation.ReadBody)).(*messageRetentionBody)
+					claims := ctx.Values().Get(auth.KeyUserClaims).(*jwt.UserClaims)
+					err := database.WithTx(ctx, r.client, func(tx *ent.Tx) error {
+						res, err := r.conversation.SetMessageRetention(ctx, tx.Client(), &SetMessageRetentionParams{
+							UserId:         claims.UserId,
+							ConversationId: params.ConversationId,
+							Retention:      body.Retention,
+						})
+
+						if err != nil {
+							return err
+						}
+
+						ctx.JSON(result.Success("", res))
+						return nil
+					})
+
+					if err != nil {
+						ctx.SetErr(err)
+						return
+					}
+				})
+
 			requireUserRouter.Get("/{conversationId}", validation.Validate[getOneParams](validation.ReadParams), func(ctx iris.Context) {
 				params := ctx.Values().Get(string(validation.ReadParams)).(*getOneParams)
 				claims := ctx.Values().Get(auth.KeyUserClaims).(*jwt.UserClaims)
@@ -110,35 +297,320 @@ func (r *Router) Register(routerGroup router.Party) {
 				ctx.JSON(result.Success("", res))
 			})
 
+			requireUserRouter.Get("/{conversationId}/draft", validation.Validate[draftParams](validation.ReadParams), func(ctx iris.Context) {
+				params := ctx.Values().Get(string(validation.ReadParams)).(*draftParams)
+				claims := ctx.Values().Get(auth.KeyUserClaims).(*jwt.UserClaims)
+				res, err := r.conversation.GetDraft(ctx, r.client, &GetDraftParams{
+					UserId:         claims.UserId,
+					ConversationId: params.ConversationId,
+				})
+
+				if err != nil {
+					ctx.SetErr(err)
+					return
+				}
+
+				ctx.JSON(result.Success("", res))
+			})
+
+			requireUserRouter.Put("/{conversationId}/draft",
+				validation.Validate[draftParams](validation.ReadParams),
+				validation.Validate[saveDraftBody](validation.ReadBody),
+				func(ctx iris.Context) {
+					params := ctx.Values().Get(string(validation.ReadParams)).(*draftParams)
+					body := ctx.Values().Get(string(validation.ReadBody)).(*saveDraftBody)
+					claims := ctx.Values().Get(auth.KeyUserClaims).(*jwt.UserClaims)
+					res, err := r.conversation.SaveDraft(ctx, r.client, &SaveDraftParams{
+						UserId:           claims.UserId,
+						ConversationId:   params.ConversationId,
+						Content:          body.Content,
+						ReplyToMessageId: body.ReplyToMessageId,
+						Media:            body.Media,
+					})
+
+					if err != nil {
+						ctx.SetErr(err)
+						return
+					}
+
+					ctx.JSON(result.Success("", res))
+				})
+
+			requireUserRouter.Delete("/{conversationId}/draft", validation.Validate[draftParams](validation.ReadParams), func(ctx iris.Context) {
+				params := ctx.Values().Get(string(validation.ReadParams)).(*draftParams)
+				claims := ctx.Values().Get(auth.KeyUserClaims).(*jwt.UserClaims)
+				err := r.conversation.ClearDraft(ctx, r.client, &ClearDraftParams{
+					UserId:         claims.UserId,
+					ConversationId: params.ConversationId,
+				})
+
+				if err != nil {
+					ctx.SetErr(err)
+					return
+				}
+
+				ctx.JSON(result.Success("", nil))
+			})
+
 			requireUserRouter.Post("/{conversationId}/message",
 				validation.Validate[createMessageParams](validation.ReadParams),
 				validation.Validate[createMessageBody](validation.ReadBody),
 				func(ctx iris.Context) {
-					database.WithTx(ctx, r.client, func(tx *ent.Tx) error {
+					var res *ent.Message
+					err := database.WithTx(ctx, r.client, func(tx *ent.Tx) error {
 						params := ctx.Values().Get(string(validation.ReadParams)).(*createMessageParams)
 						body := ctx.Values().Get(string(validation.ReadBody)).(*createMessageBody)
 						claims := ctx.Values().Get(auth.KeyUserClaims).(*jwt.UserClaims)
-						mediaList := make([]*CreateMedia, len(body.Media))
-						for i, m := range body.Media {
-							mediaList[i] = &CreateMedia{
-								Src: m.Src,
-							}
-						}
-						res, err := r.conversation.CreateMessage(ctx, tx.Client(), &CreateMessageParams{
+						var err error
+						res, err = r.conversation.CreateMessage(ctx, tx.Client(), &CreateMessageParams{
 							UserId:         claims.UserId,
 							ConversationId: params.ConversationId,
 							Content:        body.Content,
-							Media:          mediaList,
+							Entities:       body.Entities,
+							Media:          toCreateMedia(body.Media),
 						})
 
 						if err != nil {
-							ctx.SetErr(err)
 							return err
 						}
 
 						ctx.JSON(result.Success("Create message success", res))
 						return nil
 					})
+
+					if err != nil {
+						ctx.SetErr(err)
+						return
+					}
+
+					r.unfurler.Enqueue(res)
+				})
+
+			requireUserRouter.Post("/{conversationId}/poll",
+				validation.Validate[createPollParams](validation.ReadParams),
+				validation.Validate[createPollBody](validation.ReadBody),
+				func(ctx iris.Context) {
+					params := ctx.Values().Get(string(validation.ReadParams)).(*createPollParams)
+					body := ctx.Values().Get(string(validation.ReadBody)).(*createPollBody)
+					claims := ctx.Values().Get(auth.KeyUserClaims).(*jwt.UserClaims)
+					err := database.WithTx(ctx, r.client, func(tx *ent.Tx) error {
+						res, err := r.conversation.CreatePoll(ctx, tx.Client(), &CreatePollParams{
+							UserId:           claims.UserId,
+							ConversationId:   params.ConversationId,
+							Question:         body.Question,
+							Options:          body.Options,
+							IsMultipleChoice: body.IsMultipleChoice,
+							IsAnonymous:      body.IsAnonymous,
+							ClosesAt:         body.ClosesAt,
+						})
+
+						if err != nil {
+							return err
+						}
+
+						ctx.JSON(result.Success("Create poll success", res))
+						return nil
+					})
+
+					if err != nil {
+						ctx.SetErr(err)
+						return
+					}
+				})
+
+			requireUserRouter.Put("/{conversationId}/message/{messageId}/poll/vote",
+				validation.Validate[votePollParams](validation.ReadParams),
+				validation.Validate[votePollBody](validation.ReadBody),
+				func(ctx iris.Context) {
+					params := ctx.Values().Get(string(validation.ReadParams)).(*votePollParams)
+					body := ctx.Values().Get(string(validation.ReadBody)).(*votePollBody)
+					claims := ctx.Values().Get(auth.KeyUserClaims).(*jwt.UserClaims)
+					err := database.WithTx(ctx, r.client, func(tx *ent.Tx) error {
+						res, err := r.conversation.VotePoll(ctx, tx.Client(), &VotePollParams{
+							UserId:         claims.UserId,
+							ConversationId: params.ConversationId,
+							MessageId:      params.MessageId,
+							OptionIds:      body.OptionIds,
+						})
+
+						if err != nil {
+							return err
+						}
+
+						ctx.JSON(result.Success("", res))
+						return nil
+					})
+
+					if err != nil {
+						ctx.SetErr(err)
+						return
+					}
+				})
+
+			requireUserRouter.Get("/{conversationId}/pins",
+				validation.Validate[getPinsParams](validation.ReadParams),
+				validation.Validate[getPinsQuery](validation.ReadQuery),
+				func(ctx iris.Context) {
+					params := ctx.Values().Get(string(validation.ReadParams)).(*getPinsParams)
+					query := ctx.Values().Get(string(validation.ReadQuery)).(*getPinsQuery)
+					claims := ctx.Values().Get(auth.KeyUserClaims).(*jwt.UserClaims)
+					res, err := r.conversation.GetPins(ctx, r.client, &GetPinsParams{
+						UserId:         claims.UserId,
+						ConversationId: params.ConversationId,
+						Limit:          query.Limit,
+						Page:           query.Page,
+					})
+
+					if err != nil {
+						ctx.SetErr(err)
+						return
+					}
+
+					ctx.JSON(result.Success("", res))
+				})
+
+			requireUserRouter.Put("/{conversationId}/message/{messageId}/pin", validation.Validate[pinMessageParams](validation.ReadParams), func(ctx iris.Context) {
+				params := ctx.Values().Get(string(validation.ReadParams)).(*pinMessageParams)
+				claims := ctx.Values().Get(auth.KeyUserClaims).(*jwt.UserClaims)
+				res, err := r.conversation.PinMessage(ctx, r.client, &PinMessageParams{
+					UserId:         claims.UserId,
+					ConversationId: params.ConversationId,
+					MessageId:      params.MessageId,
+				})
+
+				if err != nil {
+					ctx.SetErr(err)
+					return
+				}
+
+				ctx.JSON(result.Success("Pin message success", res))
+			})
+
+			requireUserRouter.Delete("/{conversationId}/message/{messageId}/pin", validation.Validate[pinMessageParams](validation.ReadParams), func(ctx iris.Context) {
+				params := ctx.Values().Get(string(validation.ReadParams)).(*pinMessageParams)
+				claims := ctx.Values().Get(auth.KeyUserClaims).(*jwt.UserClaims)
+				err := r.conversation.UnpinMessage(ctx, r.client, &PinMessageParams{
+					UserId:         claims.UserId,
+					ConversationId: params.ConversationId,
+					MessageId:      params.MessageId,
+				})
+
+				if err != nil {
+					ctx.SetErr(err)
+					return
+				}
+
+				ctx.JSON(result.Success("Unpin message success", nil))
+			})
+
+			requireUserRouter.Post("/{conversationId}/scheduled-message",
+				validation.Validate[scheduledMessageParams](validation.ReadParams),
+				validation.Validate[createScheduledMessageBody](validation.ReadBody),
+				func(ctx iris.Context) {
+					params := ctx.Values().Get(string(validation.ReadParams)).(*scheduledMessageParams)
+					body := ctx.Values().Get(string(validation.ReadBody)).(*createScheduledMessageBody)
+					claims := ctx.Values().Get(auth.KeyUserClaims).(*jwt.UserClaims)
+					err := database.WithTx(ctx, r.client, func(tx *ent.Tx) error {
+						res, err := r.conversation.CreateScheduledMessage(ctx, tx.Client(), &CreateScheduledMessageParams{
+							UserId:         claims.UserId,
+							ConversationId: params.ConversationId,
+							Content:        body.Content,
+							Entities:       body.Entities,
+							Media:          toCreateMedia(body.Media),
+							ScheduledAt:    body.ScheduledAt,
+						})
+
+						if err != nil {
+							return err
+						}
+
+						ctx.JSON(result.Success("Schedule message success", res))
+						return nil
+					})
+
+					if err != nil {
+						ctx.SetErr(err)
+						return
+					}
+				})
+
+			requireUserRouter.Get("/{conversationId}/scheduled-message",
+				validation.Validate[scheduledMessageParams](validation.ReadParams),
+				validation.Validate[getScheduledMessageQuery](validation.ReadQuery),
+				func(ctx iris.Context) {
+					params := ctx.Values().Get(string(validation.ReadParams)).(*scheduledMessageParams)
+					query := ctx.Values().Get(string(validation.ReadQuery)).(*getScheduledMessageQuery)
+					claims := ctx.Values().Get(auth.KeyUserClaims).(*jwt.UserClaims)
+					res, err := r.conversation.GetScheduledMessage(ctx, r.client, &GetScheduledMessageParams{
+						UserId:         claims.UserId,
+						ConversationId: params.ConversationId,
+						Limit:          query.Limit,
+						Page:           query.Page,
+					})
+
+					if err != nil {
+						ctx.SetErr(err)
+						return
+					}
+
+					ctx.JSON(result.Success("", res))
+				})
+
+			requireUserRouter.Patch("/{conversationId}/scheduled-message/{scheduledMessageId}",
+				validation.Validate[updateScheduledMessageParams](validation.ReadParams),
+				validation.Validate[updateScheduledMessageBody](validation.ReadBody),
+				func(ctx iris.Context) {
+					params := ctx.Values().Get(string(validation.ReadParams)).(*updateScheduledMessageParams)
+					body := ctx.Values().Get(string(validation.ReadBody)).(*updateScheduledMessageBody)
+					claims := ctx.Values().Get(auth.KeyUserClaims).(*jwt.UserClaims)
+					err := database.WithTx(ctx, r.client, func(tx *ent.Tx) error {
+						var media []*CreateMedia
+						if body.Media != nil {
+							media = toCreateMedia(*body.Media)
+						}
+						res, err := r.conversation.UpdateScheduledMessage(ctx, tx.Client(), &UpdateScheduledMessageParams{
+							UserId:             claims.UserId,
+							ConversationId:     params.ConversationId,
+							ScheduledMessageId: params.ScheduledMessageId,
+							Content:            body.Content,
+							Entities:           body.Entities,
+							Media:              media,
+							ScheduledAt:        body.ScheduledAt,
+						})
+
+						if err != nil {
+							return err
+						}
+
+						ctx.JSON(result.Success("Update scheduled message success", res))
+						return nil
+					})
+
+					if err != nil {
+						ctx.SetErr(err)
+						return
+					}
+				})
+
+			requireUserRouter.Delete("/{conversationId}/scheduled-message/{scheduledMessageId}",
+				validation.Validate[updateScheduledMessageParams](validation.ReadParams),
+				func(ctx iris.Context) {
+					params := ctx.Values().Get(string(validation.ReadParams)).(*updateScheduledMessageParams)
+					claims := ctx.Values().Get(auth.KeyUserClaims).(*jwt.UserClaims)
+					err := database.WithTx(ctx, r.client, func(tx *ent.Tx) error {
+						return r.conversation.DeleteScheduledMessage(ctx, tx.Client(), &DeleteScheduledMessageParams{
+							UserId:             claims.UserId,
+							ConversationId:     params.ConversationId,
+							ScheduledMessageId: params.ScheduledMessageId,
+						})
+					})
+
+					if err != nil {
+						ctx.SetErr(err)
+						return
+					}
+
+					ctx.JSON(result.Success("Cancel scheduled message success", nil))
 				})
 
 			requireUserRouter.Get("/{conversationId}/message",
@@ -167,13 +639,25 @@ func (r *Router) Register(routerGroup router.Party) {
 
 }
 
+func toCreateMedia(media []*createMedia) []*CreateMedia {
+	res := make([]*CreateMedia, len(media))
+	for i, m := range media {
+		res[i] = &CreateMedia{
+			Src:  m.Src,
+			Name: m.Name,
+		}
+	}
+	return res
+}
+
 type loadBody struct {
 	UserId int `json:"userId" validate:"required"`
 }
 
 type getQuery struct {
 	pagination.Query
-	Search string `query:"search"`
+	Search   string `query:"search"`
+	Archived bool   `query:"archived"`
 }
 
 type getOneParams struct {
@@ -181,8 +665,10 @@ type getOneParams struct {
 }
 
 type createMessageBody struct {
-	Content string         `json:"content"`
-	Media   []*createMedia `json:"media"`
+	Content string `json:"content"`
+	// Offsets and lengths in UTF-16 code units of content as sent
+	Entities []*payload.MessageEntity `json:"entities"`
+	Media    []*createMedia           `json:"media"`
 }
 
 type createMessageParams struct {
@@ -190,7 +676,8 @@ type createMessageParams struct {
 }
 
 type createMedia struct {
-	Src string `json:"src"`
+	Src  string `json:"src"`
+	Name string `json:"name"`
 }
 
 type getMessageQuery struct {
@@ -200,3 +687,101 @@ type getMessageQuery struct {
 type getMessageParams struct {
 	ConversationId int `param:"conversationId" validate:"required"`
 }
+
+type memberStateParams struct {
+	ConversationId int `param:"conversationId" validate:"required"`
+}
+
+type muteBody struct {
+	MutedUntil time.Time `json:"mutedUntil" validate:"required"`
+}
+
+type reorderPinsBody struct {
+	ConversationIds []int `json:"conversationIds" validate:"required,dive,required"`
+}
+
+type getPinsQuery struct {
+	pagination.Query
+}
+
+type getPinsParams struct {
+	ConversationId int `param:"conversationId" validate:"required"`
+}
+
+type pinMessageParams struct {
+	ConversationId int `param:"conversationId" validate:"required"`
+	MessageId      int `param:"messageId" validate:"required"`
+}
+
+type forwardMessageBody struct {
+	MessageIds      []int `json:"messageIds" validate:"required,max=100,dive,required"`
+	ConversationIds []int `json:"conversationIds" validate:"required,max=20,dive,required"`
+}
+
+type scheduledMessageParams struct {
+	ConversationId int `param:"conversationId" validate:"required"`
+}
+
+type createScheduledMessageBody struct {
+	Content string `json:"content"`
+	// Offsets and lengths in UTF-16 code units of content as sent
+	Entities    []*payload.MessageEntity `json:"entities"`
+	Media       []*createMedia           `json:"media"`
+	ScheduledAt time.Time                `json:"scheduledAt" validate:"required"`
+}
+
+type getScheduledMessageQuery struct {
+	pagination.Query
+}
+
+type updateScheduledMessageParams struct {
+	ConversationId     int `param:"conversationId" validate:"required"`
+	ScheduledMessageId int `param:"scheduledMessageId" validate:"required"`
+}
+
+type updateScheduledMessageBody struct {
+	Content *string `json:"content"`
+	// Replace the formatting, new content without entities has none
+	Entities    *[]*payload.MessageEntity `json:"entities"`
+	Media       *[]*createMedia           `json:"media"`
+	ScheduledAt *time.Time                `json:"scheduledAt"`
+}
+
+type messageRetentionParams struct {
+	ConversationId int `param:"conversationId" validate:"required"`
+}
+
+type messageRetentionBody struct {
+	Retention enum.MessageRetention `json:"retention" validate:"required,oneof=off 1d 7d 30d"`
+}
+
+type createPollParams struct {
+	ConversationId int `param:"conversationId" validate:"required"`
+}
+
+type createPollBody struct {
+	Question         string     `json:"question" validate:"required,max=300"`
+	Options          []string   `json:"options" validate:"required,dive,required,max=100"`
+	IsMultipleChoice bool       `json:"isMultipleChoice"`
+	IsAnonymous      bool       `json:"isAnonymous"`
+	ClosesAt         *time.Time `json:"closesAt"`
+}
+
+type votePollParams struct {
+	ConversationId int `param:"conversationId" validate:"required"`
+	MessageId      int `param:"messageId" validate:"required"`
+}
+
+type votePollBody struct {
+	OptionIds []int `json:"optionIds" validate:"dive,required"`
+}
+
+type draftParams struct {
+	ConversationId int `param:"conversationId" validate:"required"`
+}
+
+type saveDraftBody struct {
+	Content          string   `json:"content"`
+	ReplyToMessageId *int     `json:"replyToMessageId"`
+	Media            []string `json:"media" validate:"max=10,dive,required"`
+}
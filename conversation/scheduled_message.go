@@ -0,0 +1,277 @@
+package conversation
+
+import (
+	"backend/apperror"
+	"backend/config"
+	"backend/database/ent"
+	"backend/database/ent/scheduledmessage"
+	"backend/database/ent/schema/enum"
+	"backend/database/ent/schema/payload"
+	"backend/file"
+	"backend/http/pagination"
+	"context"
+	"fmt"
+	"time"
+
+	"entgo.io/ent/dialect/sql"
+	"github.com/cockroachdb/errors"
+)
+
+func (s *Conversation) CreateScheduledMessage(ctx context.Context, client *ent.Client, p *CreateScheduledMessageParams) (*ent.ScheduledMessage, error) {
+	err := s.ValidateUserInConversation(ctx, client, &ValidateUserInConversationParams{
+		UserId:         p.UserId,
+		ConversationId: p.ConversationId,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := validateScheduledAt(p.ScheduledAt); err != nil {
+		return nil, err
+	}
+	content, offsets := sanitizeContent(p.Content)
+	entities, err := validateEntities(offsets, p.Entities)
+	if err != nil {
+		return nil, err
+	}
+	if content == "" && len(p.Media) == 0 {
+		return nil, apperror.BadRequest("Message content or media is required", nil, nil)
+	}
+
+	media, err := s.storeScheduledMedia(ctx, client, p.Media)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := client.ScheduledMessage.Create().
+		SetUserID(p.UserId).
+		SetConversationID(p.ConversationId).
+		SetContent(content).
+		SetEntities(entities).
+		SetMedia(media).
+		SetScheduledAt(p.ScheduledAt).
+		Save(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "ScheduledMessage.Create failed")
+	}
+
+	signScheduledMedia(s.file, res)
+
+	return res, nil
+}
+
+func (s *Conversation) GetScheduledMessage(ctx context.Context, client *ent.Client, p *GetScheduledMessageParams) (*pagination.Result[*ent.ScheduledMessage], error) {
+	err := s.ValidateUserInConversation(ctx, client, &ValidateUserInConversationParams{
+		UserId:         p.UserId,
+		ConversationId: p.ConversationId,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	queryBuilder := client.ScheduledMessage.
+		Query().
+		Where(
+			scheduledmessage.ConversationId(p.ConversationId),
+			scheduledmessage.UserId(p.UserId),
+			scheduledmessage.StatusNEQ(enum.ScheduledMessageStatusSent),
+		).
+		Order(ent.Asc(scheduledmessage.FieldScheduledAt))
+
+	res, err := pagination.Paginate(ctx, queryBuilder, &pagination.Query{
+		Limit: p.Limit,
+		Page:  p.Page,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "GetScheduledMessage failed")
+	}
+
+	signScheduledMedia(s.file, res.Rows...)
+
+	return res, nil
+}
+
+// UpdateScheduledMessage edits a message that has not been sent yet. A failed
+// message is rescheduled. Media is replaced only when new media is given, new
+// content without entities drops the formatting of the old one.
+func (s *Conversation) UpdateScheduledMessage(ctx context.Context, client *ent.Client, p *UpdateScheduledMessageParams) (*ent.ScheduledMessage, error) {
+	scheduled, err := s.getUnsentScheduledMessage(ctx, client, p.UserId, p.ConversationId, p.ScheduledMessageId)
+	if err != nil {
+		return nil, err
+	}
+
+	updateBuilder := scheduled.Update().
+		SetStatus(enum.ScheduledMessageStatusPending).
+		ClearFailureReason().
+		SetAttempts(0)
+	if p.Content != nil || p.Entities != nil {
+		content, entities := scheduled.Content, scheduled.Entities
+		if p.Content != nil {
+			content, entities = *p.Content, nil
+		}
+		if p.Entities != nil {
+			entities = *p.Entities
+		}
+
+		content, offsets := sanitizeContent(content)
+		entities, err := validateEntities(offsets, entities)
+		if err != nil {
+			return nil, err
+		}
+		updateBuilder.
+			SetContent(content).
+			SetEntities(entities)
+	}
+	if p.ScheduledAt != nil {
+		if err := validateScheduledAt(*p.ScheduledAt); err != nil {
+			return nil, err
+		}
+		updateBuilder.SetScheduledAt(*p.ScheduledAt)
+	}
+	if p.Media != nil {
+		media, err := s.storeScheduledMedia(ctx, client, p.Media)
+		if err != nil {
+			return nil, err
+		}
+		updateBuilder.SetMedia(media)
+	}
+
+	res, err := updateBuilder.Save(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "ScheduledMessage.Update failed")
+	}
+
+	if p.Media != nil {
+		if err := s.deleteScheduledMedia(ctx, client, scheduled.Media); err != nil {
+			return nil, err
+		}
+	}
+
+	signScheduledMedia(s.file, res)
+
+	return res, nil
+}
+
+func (s *Conversation) DeleteScheduledMessage(ctx context.Context, client *ent.Client, p *DeleteScheduledMessageParams) error {
+	scheduled, err := s.getUnsentScheduledMessage(ctx, client, p.UserId, p.ConversationId, p.ScheduledMessageId)
+	if err != nil {
+		return err
+	}
+
+	if err := client.ScheduledMessage.DeleteOne(scheduled).Exec(ctx); err != nil {
+		return errors.Wrap(err, "ScheduledMessage.Delete failed")
+	}
+
+	return s.deleteScheduledMedia(ctx, client, scheduled.Media)
+}
+
+// getUnsentScheduledMessage locks the row so the scheduler cannot send it
+// while it is being edited or canceled.
+func (s *Conversation) getUnsentScheduledMessage(ctx context.Context, client *ent.Client, userId, conversationId, scheduledMessageId int) (*ent.ScheduledMessage, error) {
+	queryBuilder := client.ScheduledMessage.
+		Query().
+		Where(
+			scheduledmessage.ID(scheduledMessageId),
+			scheduledmessage.ConversationId(conversationId),
+			scheduledmessage.UserId(userId),
+			scheduledmessage.StatusNEQ(enum.ScheduledMessageStatusSent),
+		)
+	queryBuilder.Modify(func(s *sql.Selector) {
+		s.ForUpdate()
+	})
+
+	scheduled, err := queryBuilder.First(ctx)
+	if err != nil && !ent.IsNotFound(err) {
+		return nil, errors.Wrap(err, "Query failed")
+	}
+	if scheduled == nil {
+		return nil, apperror.NotFound("Scheduled message not found", nil, nil)
+	}
+
+	return scheduled, nil
+}
+
+// storeScheduledMedia moves uploads out of the temporary folder so they are
+// not cleaned up before the message is sent.
+func (s *Conversation) storeScheduledMedia(ctx context.Context, client *ent.Client, media []*CreateMedia) ([]*payload.ScheduledMedia, error) {
+	res := make([]*payload.ScheduledMedia, 0, len(media))
+	for _, m := range media {
+		src, err := s.file.MoveFromTemporary(ctx, client, m.Src, file.FolderScheduledMedia)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, &payload.ScheduledMedia{
+			Src:  src,
+			Name: mediaName(m.Name),
+		})
+	}
+
+	return res, nil
+}
+
+func (s *Conversation) deleteScheduledMedia(ctx context.Context, client *ent.Client, media []*payload.ScheduledMedia) error {
+	for _, m := range media {
+		if err := s.file.Delete(ctx, client, m.Src); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// signScheduledMedia is signMedia for messages that are not sent yet.
+func signScheduledMedia(f file.File, scheduled ...*ent.ScheduledMessage) {
+	for _, m := range scheduled {
+		media := make([]*payload.ScheduledMedia, len(m.Media))
+		for i, item := range m.Media {
+			media[i] = &payload.ScheduledMedia{
+				Src:  f.Sign(item.Src, item.Name),
+				Name: item.Name,
+			}
+		}
+		m.Media = media
+	}
+}
+
+func validateScheduledAt(scheduledAt time.Time) error {
+	now := time.Now()
+	if !scheduledAt.After(now) {
+		return apperror.BadRequest("Scheduled time must be in the future", nil, nil)
+	}
+	if scheduledAt.After(now.AddDate(0, 0, config.MaxScheduleAheadInDay)) {
+		return apperror.BadRequest(fmt.Sprintf("Messages can be scheduled at most %d days ahead", config.MaxScheduleAheadInDay), nil, nil)
+	}
+
+	return nil
+}
+
+type CreateScheduledMessageParams struct {
+	UserId         int
+	ConversationId int
+	Content        string
+	Entities       []*payload.MessageEntity
+	Media          []*CreateMedia
+	ScheduledAt    time.Time
+}
+
+type GetScheduledMessageParams struct {
+	UserId         int
+	ConversationId int
+	Limit          int
+	Page           int
+}
+
+type UpdateScheduledMessageParams struct {
+	UserId             int
+	ConversationId     int
+	ScheduledMessageId int
+	Content            *string
+	Entities           *[]*payload.MessageEntity
+	Media              []*CreateMedia
+	ScheduledAt        *time.Time
+}
+
+type DeleteScheduledMessageParams struct {
+	UserId             int
+	ConversationId     int
+	ScheduledMessageId int
+}
@@ -0,0 +1,237 @@
+package conversation
+
+import (
+	"backend/apperror"
+	"backend/config"
+	"backend/database"
+	"backend/database/ent"
+	"backend/database/ent/scheduledmessage"
+	"backend/database/ent/schema/enum"
+	"backend/logger"
+	"context"
+	"time"
+
+	"entgo.io/ent/dialect/sql"
+	"github.com/cockroachdb/errors"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+)
+
+// sendFailedReason is shown for messages that kept failing with an unexpected
+// error.
+const sendFailedReason = "Message could not be sent"
+
+// Scheduler sends due scheduled messages in the background. Rows are claimed
+// with SKIP LOCKED so several instances can run side by side.
+type Scheduler struct {
+	client       *ent.Client
+	conversation *Conversation
+	unfurler     *Unfurler
+	logger       *logger.Logger
+}
+
+type schedulerParams struct {
+	fx.In
+	fx.Lifecycle
+	Client       *ent.Client
+	Conversation *Conversation
+	Unfurler     *Unfurler
+	Logger       *logger.Logger
+}
+
+func newScheduler(p schedulerParams) *Scheduler {
+	s := &Scheduler{
+		client:       p.Client,
+		conversation: p.Conversation,
+		unfurler:     p.Unfurler,
+		logger:       p.Logger,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	p.Lifecycle.Append(fx.Hook{
+		OnStart: func(context.Context) error {
+			go func() {
+				defer close(done)
+				s.run(ctx)
+			}()
+			return nil
+		},
+		OnStop: func(stopCtx context.Context) error {
+			cancel()
+			select {
+			case <-done:
+			case <-stopCtx.Done():
+			}
+			return nil
+		},
+	})
+
+	return s
+}
+
+func (s *Scheduler) run(ctx context.Context) {
+	ticker := time.NewTicker(config.SchedulerIntervalInSecond * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.dispatch(ctx)
+		}
+	}
+}
+
+func (s *Scheduler) dispatch(ctx context.Context) {
+	// Messages that failed are skipped until the next tick so one of them
+	// cannot hold up the rest of the batch
+	var failed []int
+	for range config.SchedulerBatchSize {
+		if ctx.Err() != nil {
+			return
+		}
+
+		id, err := s.dispatchOne(ctx, failed)
+		if err != nil {
+			s.logger.Error("Dispatch scheduled message failed", zap.Int("scheduledMessageId", id), zap.Error(err))
+			if id == 0 || ctx.Err() != nil {
+				return
+			}
+			if err := s.recordAttempt(ctx, id); err != nil {
+				s.logger.Error("Record scheduled message attempt failed", zap.Error(err))
+				return
+			}
+			failed = append(failed, id)
+			continue
+		}
+		if id == 0 {
+			return
+		}
+	}
+}
+
+// dispatchOne sends the oldest due message not in skip and returns its id, or
+// 0 when nothing is due. A message rejected by the app (e.g. the sender left
+// the conversation) is marked failed, any other error leaves it pending so it
+// is retried on the next tick.
+func (s *Scheduler) dispatchOne(ctx context.Context, skip []int) (int, error) {
+	var scheduled *ent.ScheduledMessage
+	var sent *ent.Message
+	err := database.WithTx(ctx, s.client, func(tx *ent.Tx) error {
+		queryBuilder := tx.ScheduledMessage.
+			Query().
+			Where(
+				scheduledmessage.StatusEQ(enum.ScheduledMessageStatusPending),
+				scheduledmessage.ScheduledAtLTE(time.Now()),
+				scheduledmessage.IDNotIn(skip...),
+			).
+			Order(ent.Asc(scheduledmessage.FieldScheduledAt)).
+			Limit(1)
+		queryBuilder.Modify(func(s *sql.Selector) {
+			s.ForUpdate(sql.WithLockAction(sql.SkipLocked))
+		})
+
+		var err error
+		scheduled, err = queryBuilder.First(ctx)
+		if err != nil {
+			if ent.IsNotFound(err) {
+				return nil
+			}
+			return errors.Wrap(err, "Query failed")
+		}
+
+		media := make([]*CreateMedia, len(scheduled.Media))
+		for i, m := range scheduled.Media {
+			media[i] = &CreateMedia{
+				Src:    m.Src,
+				Name:   m.Name,
+				Stored: true,
+			}
+		}
+
+		sent, err = s.conversation.CreateMessage(ctx, tx.Client(), &CreateMessageParams{
+			UserId:         scheduled.UserId,
+			ConversationId: scheduled.ConversationId,
+			Content:        scheduled.Content,
+			Entities:       scheduled.Entities,
+			Media:          media,
+			KeepDraft:      true,
+		})
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ScheduledMessage.UpdateOne(scheduled).
+			SetStatus(enum.ScheduledMessageStatusSent).
+			SetMessageId(sent.ID).
+			Save(ctx)
+		if err != nil {
+			return errors.Wrap(err, "ScheduledMessage.Update failed")
+		}
+
+		// The message holds its own copies now
+		return s.conversation.deleteScheduledMedia(ctx, tx.Client(), scheduled.Media)
+	})
+
+	var appErr *apperror.AppError
+	if errors.As(err, &appErr) {
+		_, err = s.client.ScheduledMessage.
+			Update().
+			Where(
+				scheduledmessage.ID(scheduled.ID),
+				scheduledmessage.StatusEQ(enum.ScheduledMessageStatusPending),
+			).
+			SetStatus(enum.ScheduledMessageStatusFailed).
+			SetFailureReason(appErr.Message).
+			Save(ctx)
+		if err != nil {
+			return scheduled.ID, errors.Wrap(err, "ScheduledMessage.Update failed")
+		}
+		return scheduled.ID, nil
+	}
+	if scheduled == nil {
+		return 0, err
+	}
+	if err != nil {
+		return scheduled.ID, err
+	}
+
+	s.unfurler.Enqueue(sent)
+
+	return scheduled.ID, nil
+}
+
+// recordAttempt counts a failed send and marks the message failed once it has
+// failed config.SchedulerMaxAttempts times.
+func (s *Scheduler) recordAttempt(ctx context.Context, id int) error {
+	_, err := s.client.ScheduledMessage.
+		Update().
+		Where(
+			scheduledmessage.ID(id),
+			scheduledmessage.StatusEQ(enum.ScheduledMessageStatusPending),
+		).
+		AddAttempts(1).
+		Save(ctx)
+	if err != nil {
+		return errors.Wrap(err, "ScheduledMessage.Update failed")
+	}
+
+	_, err = s.client.ScheduledMessage.
+		Update().
+		Where(
+			scheduledmessage.ID(id),
+			scheduledmessage.StatusEQ(enum.ScheduledMessageStatusPending),
+			scheduledmessage.AttemptsGTE(config.SchedulerMaxAttempts),
+		).
+		SetStatus(enum.ScheduledMessageStatusFailed).
+		SetFailureReason(sendFailedReason).
+		Save(ctx)
+	if err != nil {
+		return errors.Wrap(err, "ScheduledMessage.Update failed")
+	}
+
+	return nil
+}
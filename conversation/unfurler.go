@@ -0,0 +1,177 @@
+package conversation
+
+import (
+	"backend/common/result"
+	"backend/config"
+	"backend/database/ent"
+	"backend/database/ent/conversationmember"
+	"backend/database/ent/message"
+	"backend/file"
+	"backend/linkpreview"
+	"backend/logger"
+	"backend/websocket"
+	"context"
+	"strconv"
+	"sync"
+
+	"github.com/cockroachdb/errors"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+)
+
+// Unfurler attaches link previews to messages in the background, so sending a
+// message never waits on a remote page. Clients get the previews through a
+// messageUpdated event.
+type Unfurler struct {
+	client      *ent.Client
+	file        file.File
+	linkPreview *linkpreview.LinkPreview
+	websocket   *websocket.Websocket
+	logger      *logger.Logger
+	jobs        chan *unfurlJob
+}
+
+type unfurlJob struct {
+	messageId      int
+	conversationId int
+	links          []string
+}
+
+type unfurlerParams struct {
+	fx.In
+	fx.Lifecycle
+	Client      *ent.Client
+	File        file.File
+	LinkPreview *linkpreview.LinkPreview
+	Websocket   *websocket.Websocket
+	Logger      *logger.Logger
+}
+
+func newUnfurler(p unfurlerParams) *Unfurler {
+	u := &Unfurler{
+		client:      p.Client,
+		file:        p.File,
+		linkPreview: p.LinkPreview,
+		websocket:   p.Websocket,
+		logger:      p.Logger,
+		jobs:        make(chan *unfurlJob, config.LinkPreviewQueueSize),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+
+	p.Lifecycle.Append(fx.Hook{
+		OnStart: func(context.Context) error {
+			for range config.LinkPreviewWorkers {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					u.work(ctx)
+				}()
+			}
+			return nil
+		},
+		OnStop: func(stopCtx context.Context) error {
+			cancel()
+			done := make(chan struct{})
+			go func() {
+				wg.Wait()
+				close(done)
+			}()
+			select {
+			case <-done:
+			case <-stopCtx.Done():
+			}
+			return nil
+		},
+	})
+
+	return u
+}
+
+// Enqueue schedules previews for the links in a message. It must be called
+// after the message is committed, otherwise a worker may not find it.
+func (u *Unfurler) Enqueue(m *ent.Message) {
+	links := parseLinks(m.Content)
+	if len(links) == 0 {
+		return
+	}
+
+	select {
+	case u.jobs <- &unfurlJob{
+		messageId:      m.ID,
+		conversationId: m.ConversationId,
+		links:          links,
+	}:
+	default:
+		u.logger.Warn("Link preview queue is full", zap.Int("messageId", m.ID))
+	}
+}
+
+func (u *Unfurler) work(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case job := <-u.jobs:
+			if err := u.unfurl(ctx, job); err != nil {
+				u.logger.Error("Unfurl links failed", zap.Int("messageId", job.messageId), zap.Error(err))
+			}
+		}
+	}
+}
+
+func (u *Unfurler) unfurl(ctx context.Context, job *unfurlJob) error {
+	var previews []*ent.LinkPreview
+	for _, link := range job.links {
+		preview, err := u.linkPreview.Get(ctx, u.client, link)
+		if err != nil {
+			return err
+		}
+		if linkpreview.IsEmpty(preview) {
+			continue
+		}
+		previews = append(previews, preview)
+	}
+	if len(previews) == 0 {
+		return nil
+	}
+
+	err := u.client.Message.UpdateOneID(job.messageId).AddLinkPreviews(previews...).Exec(ctx)
+	if err != nil {
+		// The message disappeared while its links were fetched
+		if ent.IsNotFound(err) {
+			return nil
+		}
+		return errors.Wrap(err, "Message.Update failed")
+	}
+
+	m, err := u.client.Message.
+		Query().
+		Where(message.ID(job.messageId)).
+		WithMedia().
+		WithMentions().
+		WithLinkPreviews().
+		Only(ctx)
+	if err != nil {
+		return errors.Wrap(err, "Message.Query failed")
+	}
+	signMedia(u.file, m)
+
+	members, err := u.client.ConversationMember.
+		Query().
+		Where(conversationmember.ConversationId(job.conversationId)).
+		All(ctx)
+	if err != nil {
+		return errors.Wrap(err, "Query failed")
+	}
+	for _, member := range members {
+		u.websocket.Clients().Group(
+			strconv.Itoa(member.UserId)).
+			Send(websocket.EventMessageUpdated,
+				result.Success("", m),
+			)
+	}
+
+	return nil
+}
@@ -31,6 +31,7 @@ func main() {
 		`,
 		Features: []gen.Feature{
 			gen.FeatureModifier,
+			gen.FeatureUpsert,
 		},
 	}, opts...)
 	if err != nil {
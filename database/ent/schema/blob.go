@@ -0,0 +1,37 @@
+package schema
+
+import (
+	"backend/database/ent/schema/mixin"
+
+	"entgo.io/ent"
+	"entgo.io/ent/dialect/entsql"
+	"entgo.io/ent/schema"
+	"entgo.io/ent/schema/field"
+)
+
+// Blob is a stored file named after the SHA-256 of its content, shared by
+// every message media, avatar or cover with the same content. refCount counts
+// those references, the janitor deletes the file once it has none left.
+type Blob struct {
+	ent.Schema
+}
+
+func (Blob) Annotations() []schema.Annotation {
+	return []schema.Annotation{
+		entsql.Annotation{Table: "blob"},
+	}
+}
+
+func (Blob) Mixin() []ent.Mixin {
+	return []ent.Mixin{
+		mixin.Timestamp{},
+	}
+}
+
+func (Blob) Fields() []ent.Field {
+	return []ent.Field{
+		field.String("key").Unique(),
+		field.Int64("size"),
+		field.Int("refCount").StorageKey("ref_count").Default(0),
+	}
+}
@@ -1,12 +1,14 @@
 package schema
 
 import (
+	"backend/database/ent/schema/enum"
 	"backend/database/ent/schema/mixin"
 
 	"entgo.io/ent"
 	"entgo.io/ent/dialect/entsql"
 	"entgo.io/ent/schema"
 	"entgo.io/ent/schema/edge"
+	"entgo.io/ent/schema/field"
 )
 
 type Conversation struct {
@@ -26,12 +28,17 @@ func (Conversation) Mixin() []ent.Mixin {
 }
 
 func (Conversation) Fields() []ent.Field {
-	return []ent.Field{}
+	return []ent.Field{
+		field.Enum("messageRetention").StorageKey("message_retention").GoType(enum.MessageRetention("")).Default(string(enum.MessageRetentionOff)),
+	}
 }
 
 func (Conversation) Edges() []ent.Edge {
 	return []ent.Edge{
 		edge.To("members", ConversationMember.Type),
 		edge.To("messages", Message.Type),
+		edge.To("pinnedMessages", PinnedMessage.Type),
+		edge.To("scheduledMessages", ScheduledMessage.Type),
+		edge.To("drafts", Draft.Type),
 	}
 }
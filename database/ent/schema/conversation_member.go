@@ -1,6 +1,8 @@
 package schema
 
 import (
+	"backend/database/ent/schema/enum"
+
 	"entgo.io/ent"
 	"entgo.io/ent/dialect/entsql"
 	"entgo.io/ent/schema"
@@ -29,6 +31,10 @@ func (ConversationMember) Fields() []ent.Field {
 	return []ent.Field{
 		field.Int("conversationId").StorageKey("conversation_id"),
 		field.Int("userId").StorageKey("user_id"),
+		field.Enum("role").GoType(enum.ConversationRole("")).Default(string(enum.ConversationRoleMember)),
+		field.Time("mutedUntil").StorageKey("muted_until").Optional().Nillable(),
+		field.Int("pinnedOrder").StorageKey("pinned_order").Optional().Nillable(),
+		field.Bool("isArchived").StorageKey("is_archived").Default(false),
 	}
 }
 
@@ -0,0 +1,58 @@
+package schema
+
+import (
+	"backend/database/ent/schema/mixin"
+
+	"entgo.io/ent"
+	"entgo.io/ent/dialect/entsql"
+	"entgo.io/ent/schema"
+	"entgo.io/ent/schema/edge"
+	"entgo.io/ent/schema/field"
+	"entgo.io/ent/schema/index"
+)
+
+type Draft struct {
+	ent.Schema
+}
+
+func (Draft) Annotations() []schema.Annotation {
+	return []schema.Annotation{
+		entsql.Annotation{Table: "draft"},
+	}
+}
+
+func (Draft) Indexes() []ent.Index {
+	return []ent.Index{
+		index.Fields("conversationId", "userId").Unique(),
+	}
+}
+
+func (Draft) Mixin() []ent.Mixin {
+	return []ent.Mixin{
+		mixin.Timestamp{},
+	}
+}
+
+// media holds names of files still in the temporary folder, they are only
+// moved when the message is sent. replyToMessageId is not a foreign key so a
+// draft survives the deletion of the message it replies to.
+func (Draft) Fields() []ent.Field {
+	return []ent.Field{
+		field.String("content").Optional(),
+		field.Int("replyToMessageId").StorageKey("reply_to_message_id").Optional().Nillable(),
+		field.Strings("media").Optional(),
+		field.Int("conversationId").StorageKey("conversation_id"),
+		field.Int("userId").StorageKey("user_id"),
+	}
+}
+
+func (Draft) Edges() []ent.Edge {
+	return []ent.Edge{
+		edge.From("conversation", Conversation.Type).
+			Ref("drafts").Field("conversationId").
+			Unique().Required(),
+		edge.From("user", User.Type).
+			Ref("drafts").Field("userId").
+			Unique().Required(),
+	}
+}
@@ -0,0 +1,18 @@
+package enum
+
+// ConversationRole is the role of a member inside a conversation.
+type ConversationRole string
+
+const (
+	ConversationRoleOwner  ConversationRole = "owner"
+	ConversationRoleAdmin  ConversationRole = "admin"
+	ConversationRoleMember ConversationRole = "member"
+)
+
+func (ConversationRole) Values() []string {
+	return []string{
+		string(ConversationRoleOwner),
+		string(ConversationRoleAdmin),
+		string(ConversationRoleMember),
+	}
+}
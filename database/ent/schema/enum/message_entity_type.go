@@ -0,0 +1,26 @@
+package enum
+
+// MessageEntityType is a formatting applied to a range of message content.
+type MessageEntityType string
+
+const (
+	MessageEntityTypeBold          MessageEntityType = "bold"
+	MessageEntityTypeItalic        MessageEntityType = "italic"
+	MessageEntityTypeUnderline     MessageEntityType = "underline"
+	MessageEntityTypeStrikethrough MessageEntityType = "strikethrough"
+	MessageEntityTypeCode          MessageEntityType = "code"
+	MessageEntityTypePre           MessageEntityType = "pre"
+	MessageEntityTypeLink          MessageEntityType = "link"
+)
+
+func (MessageEntityType) Values() []string {
+	return []string{
+		string(MessageEntityTypeBold),
+		string(MessageEntityTypeItalic),
+		string(MessageEntityTypeUnderline),
+		string(MessageEntityTypeStrikethrough),
+		string(MessageEntityTypeCode),
+		string(MessageEntityTypePre),
+		string(MessageEntityTypeLink),
+	}
+}
@@ -0,0 +1,21 @@
+package enum
+
+// MessageRetention is how long messages of a conversation are kept before
+// they disappear.
+type MessageRetention string
+
+const (
+	MessageRetentionOff     MessageRetention = "off"
+	MessageRetentionOneDay  MessageRetention = "1d"
+	MessageRetentionOneWeek MessageRetention = "7d"
+	MessageRetentionMonth   MessageRetention = "30d"
+)
+
+func (MessageRetention) Values() []string {
+	return []string{
+		string(MessageRetentionOff),
+		string(MessageRetentionOneDay),
+		string(MessageRetentionOneWeek),
+		string(MessageRetentionMonth),
+	}
+}
@@ -0,0 +1,19 @@
+package enum
+
+// MessageStatus is the aggregated delivery state of a message: delivered once
+// every recipient acknowledged it, read once it has been seen.
+type MessageStatus string
+
+const (
+	MessageStatusSent      MessageStatus = "sent"
+	MessageStatusDelivered MessageStatus = "delivered"
+	MessageStatusRead      MessageStatus = "read"
+)
+
+func (MessageStatus) Values() []string {
+	return []string{
+		string(MessageStatusSent),
+		string(MessageStatusDelivered),
+		string(MessageStatusRead),
+	}
+}
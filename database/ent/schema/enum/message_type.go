@@ -0,0 +1,19 @@
+package enum
+
+type MessageType string
+
+const (
+	MessageTypeText   MessageType = "text"
+	MessageTypeMedia  MessageType = "media"
+	MessageTypeSystem MessageType = "system"
+	MessageTypePoll   MessageType = "poll"
+)
+
+func (MessageType) Values() []string {
+	return []string{
+		string(MessageTypeText),
+		string(MessageTypeMedia),
+		string(MessageTypeSystem),
+		string(MessageTypePoll),
+	}
+}
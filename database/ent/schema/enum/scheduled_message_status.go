@@ -0,0 +1,17 @@
+package enum
+
+type ScheduledMessageStatus string
+
+const (
+	ScheduledMessageStatusPending ScheduledMessageStatus = "pending"
+	ScheduledMessageStatusSent    ScheduledMessageStatus = "sent"
+	ScheduledMessageStatusFailed  ScheduledMessageStatus = "failed"
+)
+
+func (ScheduledMessageStatus) Values() []string {
+	return []string{
+		string(ScheduledMessageStatusPending),
+		string(ScheduledMessageStatusSent),
+		string(ScheduledMessageStatusFailed),
+	}
+}
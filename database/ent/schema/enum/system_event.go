@@ -0,0 +1,9 @@
+package enum
+
+// SystemEvent is the kind of conversation event a system message announces.
+type SystemEvent string
+
+const (
+	SystemEventConversationCreated     SystemEvent = "conversationCreated"
+	SystemEventMessageRetentionChanged SystemEvent = "messageRetentionChanged"
+)
@@ -0,0 +1,19 @@
+package enum
+
+// Visibility controls which users can see a piece of user data.
+type Visibility string
+
+const (
+	VisibilityEveryone Visibility = "everyone"
+	// VisibilityContacts limits data to users sharing a conversation with the owner.
+	VisibilityContacts Visibility = "contacts"
+	VisibilityNobody   Visibility = "nobody"
+)
+
+func (Visibility) Values() []string {
+	return []string{
+		string(VisibilityEveryone),
+		string(VisibilityContacts),
+		string(VisibilityNobody),
+	}
+}
@@ -0,0 +1,37 @@
+package schema
+
+import (
+	"backend/database/ent/schema/mixin"
+
+	"entgo.io/ent"
+	"entgo.io/ent/dialect/entsql"
+	"entgo.io/ent/schema"
+	"entgo.io/ent/schema/field"
+)
+
+// FileHash is the SHA-256 of a stored file not named after its content,
+// served as its entity tag. version identifies the content that was hashed
+// by its size and modification time, a file changed since is hashed again.
+type FileHash struct {
+	ent.Schema
+}
+
+func (FileHash) Annotations() []schema.Annotation {
+	return []schema.Annotation{
+		entsql.Annotation{Table: "file_hash"},
+	}
+}
+
+func (FileHash) Mixin() []ent.Mixin {
+	return []ent.Mixin{
+		mixin.Timestamp{},
+	}
+}
+
+func (FileHash) Fields() []ent.Field {
+	return []ent.Field{
+		field.String("key").Unique(),
+		field.String("hash"),
+		field.String("version"),
+	}
+}
@@ -0,0 +1,55 @@
+package schema
+
+import (
+	"backend/database/ent/schema/mixin"
+
+	"entgo.io/ent"
+	"entgo.io/ent/dialect/entsql"
+	"entgo.io/ent/schema"
+	"entgo.io/ent/schema/edge"
+	"entgo.io/ent/schema/field"
+	"entgo.io/ent/schema/index"
+)
+
+type LinkPreview struct {
+	ent.Schema
+}
+
+func (LinkPreview) Annotations() []schema.Annotation {
+	return []schema.Annotation{
+		entsql.Annotation{Table: "link_preview"},
+	}
+}
+
+func (LinkPreview) Indexes() []ent.Index {
+	return []ent.Index{
+		index.Fields("url").Unique(),
+	}
+}
+
+func (LinkPreview) Mixin() []ent.Mixin {
+	return []ent.Mixin{
+		mixin.Timestamp{},
+	}
+}
+
+// Previews are cached by url and shared between messages. A page without any
+// metadata is cached too, with empty fields, so it is not fetched again until
+// fetchedAt is stale.
+func (LinkPreview) Fields() []ent.Field {
+	return []ent.Field{
+		field.String("url").MaxLen(2048),
+		field.String("title").MaxLen(300).Optional(),
+		field.String("description").MaxLen(1000).Optional(),
+		field.String("siteName").StorageKey("site_name").MaxLen(100).Optional(),
+		field.String("image").Optional(),
+		field.Time("fetchedAt").StorageKey("fetched_at"),
+	}
+}
+
+func (LinkPreview) Edges() []ent.Edge {
+	return []ent.Edge{
+		edge.From("messages", Message.Type).
+			Ref("linkPreviews"),
+	}
+}
@@ -1,13 +1,16 @@
 package schema
 
 import (
+	"backend/database/ent/schema/enum"
 	"backend/database/ent/schema/mixin"
+	"backend/database/ent/schema/payload"
 
 	"entgo.io/ent"
 	"entgo.io/ent/dialect/entsql"
 	"entgo.io/ent/schema"
 	"entgo.io/ent/schema/edge"
 	"entgo.io/ent/schema/field"
+	"entgo.io/ent/schema/index"
 )
 
 type Message struct {
@@ -20,6 +23,12 @@ func (Message) Annotations() []schema.Annotation {
 	}
 }
 
+func (Message) Indexes() []ent.Index {
+	return []ent.Index{
+		index.Fields("expiresAt"),
+	}
+}
+
 func (Message) Mixin() []ent.Mixin {
 	return []ent.Mixin{
 		mixin.Timestamp{},
@@ -29,9 +38,24 @@ func (Message) Mixin() []ent.Mixin {
 func (Message) Fields() []ent.Field {
 	return []ent.Field{
 		field.String("content").Optional(),
+		// content is plain text, formatting lives in entities so clients never
+		// render markup sent by other users. plainText is content collapsed to
+		// a single line, used for search and previews.
+		field.JSON("entities", []*payload.MessageEntity{}).Optional(),
+		field.Text("plainText").StorageKey("plain_text").Optional(),
 		field.Bool("isSeen").Default(false),
+		field.Enum("status").GoType(enum.MessageStatus("")).Default(string(enum.MessageStatusSent)),
+		field.Enum("type").GoType(enum.MessageType("")).Default(string(enum.MessageTypeText)),
+		// payload is only set on system messages, userId is the member who
+		// caused the event.
+		field.JSON("payload", &payload.SystemMessage{}).Optional(),
+		// expiresAt is set from the conversation retention when the message is
+		// created. Expired messages are deleted by the reaper.
+		field.Time("expiresAt").StorageKey("expires_at").Optional().Nillable(),
 		field.Int("conversationId").StorageKey("conversation_id"),
 		field.Int("userId").StorageKey("user_id"),
+		field.Int("forwardedFromUserId").StorageKey("forwarded_from_user_id").Optional().Nillable(),
+		field.Int("forwardedFromConversationId").StorageKey("forwarded_from_conversation_id").Optional().Nillable(),
 	}
 }
 
@@ -43,6 +67,15 @@ func (Message) Edges() []ent.Edge {
 		edge.From("user", User.Type).
 			Ref("messages").Field("userId").
 			Unique().Required(),
+		edge.From("forwardedFrom", User.Type).
+			Ref("forwardedMessages").Field("forwardedFromUserId").
+			Unique(),
 		edge.To("media", MessageMedia.Type),
+		edge.To("mentions", MessageMention.Type),
+		edge.To("pin", PinnedMessage.Type).Unique(),
+		edge.To("poll", Poll.Type).Unique(),
+		edge.To("deliveries", MessageDelivery.Type),
+		edge.To("linkPreviews", LinkPreview.Type).
+			StorageKey(edge.Table("message_link_preview"), edge.Columns("message_id", "link_preview_id")),
 	}
 }
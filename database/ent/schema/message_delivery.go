@@ -0,0 +1,54 @@
+package schema
+
+import (
+	"backend/database/ent/schema/mixin"
+
+	"entgo.io/ent"
+	"entgo.io/ent/dialect/entsql"
+	"entgo.io/ent/schema"
+	"entgo.io/ent/schema/edge"
+	"entgo.io/ent/schema/field"
+	"entgo.io/ent/schema/index"
+)
+
+type MessageDelivery struct {
+	ent.Schema
+}
+
+func (MessageDelivery) Annotations() []schema.Annotation {
+	return []schema.Annotation{
+		entsql.Annotation{Table: "message_delivery"},
+	}
+}
+
+func (MessageDelivery) Indexes() []ent.Index {
+	return []ent.Index{
+		index.Fields("messageId", "userId").Unique(),
+	}
+}
+
+func (MessageDelivery) Mixin() []ent.Mixin {
+	return []ent.Mixin{
+		mixin.Timestamp{},
+	}
+}
+
+// userId is the recipient whose client acknowledged the message, createdAt is
+// when.
+func (MessageDelivery) Fields() []ent.Field {
+	return []ent.Field{
+		field.Int("messageId").StorageKey("message_id"),
+		field.Int("userId").StorageKey("user_id"),
+	}
+}
+
+func (MessageDelivery) Edges() []ent.Edge {
+	return []ent.Edge{
+		edge.From("message", Message.Type).
+			Ref("deliveries").Field("messageId").
+			Unique().Required(),
+		edge.From("user", User.Type).
+			Ref("messageDeliveries").Field("userId").
+			Unique().Required(),
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"entgo.io/ent/schema"
 	"entgo.io/ent/schema/edge"
 	"entgo.io/ent/schema/field"
+	"entgo.io/ent/schema/index"
 )
 
 type MessageMedia struct {
@@ -20,6 +21,12 @@ func (MessageMedia) Annotations() []schema.Annotation {
 	}
 }
 
+func (MessageMedia) Indexes() []ent.Index {
+	return []ent.Index{
+		index.Fields("src"),
+	}
+}
+
 func (MessageMedia) Mixin() []ent.Mixin {
 	return []ent.Mixin{
 		mixin.Timestamp{},
@@ -29,6 +36,13 @@ func (MessageMedia) Mixin() []ent.Mixin {
 func (MessageMedia) Fields() []ent.Field {
 	return []ent.Field{
 		field.String("src"),
+		field.String("name").Optional(),
+		field.String("mimeType").StorageKey("mime_type").Optional(),
+		field.Int64("size").Optional(),
+		field.Int("width").Optional().Nillable(),
+		field.Int("height").Optional().Nillable(),
+		field.Float("duration").Optional().Nillable(),
+		field.Ints("variants").Optional(),
 		field.Int("messageId").StorageKey("message_id"),
 	}
 }
@@ -0,0 +1,48 @@
+package schema
+
+import (
+	"entgo.io/ent"
+	"entgo.io/ent/dialect/entsql"
+	"entgo.io/ent/schema"
+	"entgo.io/ent/schema/edge"
+	"entgo.io/ent/schema/field"
+	"entgo.io/ent/schema/index"
+)
+
+type MessageMention struct {
+	ent.Schema
+}
+
+func (MessageMention) Annotations() []schema.Annotation {
+	return []schema.Annotation{
+		entsql.Annotation{Table: "message_mention"},
+	}
+}
+
+func (MessageMention) Indexes() []ent.Index {
+	return []ent.Index{
+		index.Fields("userId"),
+	}
+}
+
+// Offset and length are counted in UTF-16 code units of the message content,
+// like formatting entities, and cover the leading '@'.
+func (MessageMention) Fields() []ent.Field {
+	return []ent.Field{
+		field.Int("offset"),
+		field.Int("length"),
+		field.Int("messageId").StorageKey("message_id"),
+		field.Int("userId").StorageKey("user_id"),
+	}
+}
+
+func (MessageMention) Edges() []ent.Edge {
+	return []ent.Edge{
+		edge.From("message", Message.Type).
+			Ref("mentions").Field("messageId").
+			Unique().Required(),
+		edge.From("user", User.Type).
+			Ref("mentions").Field("userId").
+			Unique().Required(),
+	}
+}
@@ -0,0 +1,13 @@
+package payload
+
+import "backend/database/ent/schema/enum"
+
+// MessageEntity formats a range of the message content. Offset and length are
+// counted in UTF-16 code units, like string indices in JavaScript, and like
+// mentions. URL is only set on links.
+type MessageEntity struct {
+	Type   enum.MessageEntityType `json:"type"`
+	Offset int                    `json:"offset"`
+	Length int                    `json:"length"`
+	URL    string                 `json:"url,omitempty"`
+}
@@ -0,0 +1,9 @@
+package payload
+
+// ScheduledMedia is a file of a scheduled message, Src is its path in the
+// scheduled media folder and Name the original file name, shown to
+// recipients once the message is sent.
+type ScheduledMedia struct {
+	Src  string `json:"src"`
+	Name string `json:"name,omitempty"`
+}
@@ -0,0 +1,11 @@
+package payload
+
+import "backend/database/ent/schema/enum"
+
+// SystemMessage is the structured payload of a system message. Clients render
+// the text themselves from the event and the message author, so only the data
+// specific to the event is stored.
+type SystemMessage struct {
+	Event     enum.SystemEvent      `json:"event"`
+	Retention enum.MessageRetention `json:"retention,omitempty"`
+}
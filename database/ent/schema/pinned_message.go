@@ -0,0 +1,58 @@
+package schema
+
+import (
+	"backend/database/ent/schema/mixin"
+
+	"entgo.io/ent"
+	"entgo.io/ent/dialect/entsql"
+	"entgo.io/ent/schema"
+	"entgo.io/ent/schema/edge"
+	"entgo.io/ent/schema/field"
+	"entgo.io/ent/schema/index"
+)
+
+type PinnedMessage struct {
+	ent.Schema
+}
+
+func (PinnedMessage) Annotations() []schema.Annotation {
+	return []schema.Annotation{
+		entsql.Annotation{Table: "pinned_message"},
+	}
+}
+
+func (PinnedMessage) Indexes() []ent.Index {
+	return []ent.Index{
+		index.Fields("messageId").Unique(),
+		index.Fields("conversationId"),
+	}
+}
+
+func (PinnedMessage) Mixin() []ent.Mixin {
+	return []ent.Mixin{
+		mixin.Timestamp{},
+	}
+}
+
+// userId is the member who pinned the message, createdAt is when.
+func (PinnedMessage) Fields() []ent.Field {
+	return []ent.Field{
+		field.Int("conversationId").StorageKey("conversation_id"),
+		field.Int("messageId").StorageKey("message_id"),
+		field.Int("userId").StorageKey("user_id"),
+	}
+}
+
+func (PinnedMessage) Edges() []ent.Edge {
+	return []ent.Edge{
+		edge.From("conversation", Conversation.Type).
+			Ref("pinnedMessages").Field("conversationId").
+			Unique().Required(),
+		edge.From("message", Message.Type).
+			Ref("pin").Field("messageId").
+			Unique().Required(),
+		edge.From("user", User.Type).
+			Ref("pinnedMessages").Field("userId").
+			Unique().Required(),
+	}
+}
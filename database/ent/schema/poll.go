@@ -0,0 +1,55 @@
+package schema
+
+import (
+	"backend/database/ent/schema/mixin"
+
+	"entgo.io/ent"
+	"entgo.io/ent/dialect/entsql"
+	"entgo.io/ent/schema"
+	"entgo.io/ent/schema/edge"
+	"entgo.io/ent/schema/field"
+	"entgo.io/ent/schema/index"
+)
+
+type Poll struct {
+	ent.Schema
+}
+
+func (Poll) Annotations() []schema.Annotation {
+	return []schema.Annotation{
+		entsql.Annotation{Table: "poll"},
+	}
+}
+
+func (Poll) Indexes() []ent.Index {
+	return []ent.Index{
+		index.Fields("messageId").Unique(),
+	}
+}
+
+func (Poll) Mixin() []ent.Mixin {
+	return []ent.Mixin{
+		mixin.Timestamp{},
+	}
+}
+
+// closesAt is optional, a poll without it stays open.
+func (Poll) Fields() []ent.Field {
+	return []ent.Field{
+		field.String("question").MaxLen(300),
+		field.Bool("isMultipleChoice").StorageKey("is_multiple_choice").Default(false),
+		field.Bool("isAnonymous").StorageKey("is_anonymous").Default(false),
+		field.Time("closesAt").StorageKey("closes_at").Optional().Nillable(),
+		field.Int("messageId").StorageKey("message_id"),
+	}
+}
+
+func (Poll) Edges() []ent.Edge {
+	return []ent.Edge{
+		edge.From("message", Message.Type).
+			Ref("poll").Field("messageId").
+			Unique().Required(),
+		edge.To("options", PollOption.Type),
+		edge.To("votes", PollVote.Type),
+	}
+}
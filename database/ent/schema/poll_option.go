@@ -0,0 +1,54 @@
+package schema
+
+import (
+	"backend/database/ent/schema/mixin"
+
+	"entgo.io/ent"
+	"entgo.io/ent/dialect/entsql"
+	"entgo.io/ent/schema"
+	"entgo.io/ent/schema/edge"
+	"entgo.io/ent/schema/field"
+	"entgo.io/ent/schema/index"
+)
+
+type PollOption struct {
+	ent.Schema
+}
+
+func (PollOption) Annotations() []schema.Annotation {
+	return []schema.Annotation{
+		entsql.Annotation{Table: "poll_option"},
+	}
+}
+
+func (PollOption) Indexes() []ent.Index {
+	return []ent.Index{
+		index.Fields("pollId"),
+	}
+}
+
+func (PollOption) Mixin() []ent.Mixin {
+	return []ent.Mixin{
+		mixin.Timestamp{},
+	}
+}
+
+// voteCount is kept in sync with the votes of the option so results can be
+// read without aggregating votes.
+func (PollOption) Fields() []ent.Field {
+	return []ent.Field{
+		field.String("text").MaxLen(100),
+		field.Int("position"),
+		field.Int("voteCount").StorageKey("vote_count").Default(0),
+		field.Int("pollId").StorageKey("poll_id"),
+	}
+}
+
+func (PollOption) Edges() []ent.Edge {
+	return []ent.Edge{
+		edge.From("poll", Poll.Type).
+			Ref("options").Field("pollId").
+			Unique().Required(),
+		edge.To("votes", PollVote.Type),
+	}
+}
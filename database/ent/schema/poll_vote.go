@@ -0,0 +1,57 @@
+package schema
+
+import (
+	"backend/database/ent/schema/mixin"
+
+	"entgo.io/ent"
+	"entgo.io/ent/dialect/entsql"
+	"entgo.io/ent/schema"
+	"entgo.io/ent/schema/edge"
+	"entgo.io/ent/schema/field"
+	"entgo.io/ent/schema/index"
+)
+
+type PollVote struct {
+	ent.Schema
+}
+
+func (PollVote) Annotations() []schema.Annotation {
+	return []schema.Annotation{
+		entsql.Annotation{Table: "poll_vote"},
+	}
+}
+
+func (PollVote) Indexes() []ent.Index {
+	return []ent.Index{
+		index.Fields("optionId", "userId").Unique(),
+		index.Fields("pollId", "userId"),
+	}
+}
+
+func (PollVote) Mixin() []ent.Mixin {
+	return []ent.Mixin{
+		mixin.Timestamp{},
+	}
+}
+
+func (PollVote) Fields() []ent.Field {
+	return []ent.Field{
+		field.Int("pollId").StorageKey("poll_id"),
+		field.Int("optionId").StorageKey("option_id"),
+		field.Int("userId").StorageKey("user_id"),
+	}
+}
+
+func (PollVote) Edges() []ent.Edge {
+	return []ent.Edge{
+		edge.From("poll", Poll.Type).
+			Ref("votes").Field("pollId").
+			Unique().Required(),
+		edge.From("option", PollOption.Type).
+			Ref("votes").Field("optionId").
+			Unique().Required(),
+		edge.From("user", User.Type).
+			Ref("pollVotes").Field("userId").
+			Unique().Required(),
+	}
+}
@@ -0,0 +1,66 @@
+package schema
+
+import (
+	"backend/database/ent/schema/enum"
+	"backend/database/ent/schema/mixin"
+	"backend/database/ent/schema/payload"
+
+	"entgo.io/ent"
+	"entgo.io/ent/dialect/entsql"
+	"entgo.io/ent/schema"
+	"entgo.io/ent/schema/edge"
+	"entgo.io/ent/schema/field"
+	"entgo.io/ent/schema/index"
+)
+
+type ScheduledMessage struct {
+	ent.Schema
+}
+
+func (ScheduledMessage) Annotations() []schema.Annotation {
+	return []schema.Annotation{
+		entsql.Annotation{Table: "scheduled_message"},
+	}
+}
+
+func (ScheduledMessage) Indexes() []ent.Index {
+	return []ent.Index{
+		index.Fields("status", "scheduledAt"),
+	}
+}
+
+func (ScheduledMessage) Mixin() []ent.Mixin {
+	return []ent.Mixin{
+		mixin.Timestamp{},
+	}
+}
+
+// content and entities are stored sanitized and validated, as the message
+// will be sent. media holds the files kept in the scheduled media folder until
+// the message is sent. messageId is set once the message has been sent. attempts
+// counts the sends that failed with an unexpected error.
+func (ScheduledMessage) Fields() []ent.Field {
+	return []ent.Field{
+		field.String("content").Optional(),
+		field.JSON("entities", []*payload.MessageEntity{}).Optional(),
+		field.JSON("media", []*payload.ScheduledMedia{}).Optional(),
+		field.Time("scheduledAt").StorageKey("scheduled_at"),
+		field.Enum("status").GoType(enum.ScheduledMessageStatus("")).Default(string(enum.ScheduledMessageStatusPending)),
+		field.String("failureReason").StorageKey("failure_reason").Optional(),
+		field.Int("attempts").Default(0),
+		field.Int("messageId").StorageKey("message_id").Optional().Nillable(),
+		field.Int("conversationId").StorageKey("conversation_id"),
+		field.Int("userId").StorageKey("user_id"),
+	}
+}
+
+func (ScheduledMessage) Edges() []ent.Edge {
+	return []ent.Edge{
+		edge.From("conversation", Conversation.Type).
+			Ref("scheduledMessages").Field("conversationId").
+			Unique().Required(),
+		edge.From("user", User.Type).
+			Ref("scheduledMessages").Field("userId").
+			Unique().Required(),
+	}
+}
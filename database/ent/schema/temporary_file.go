@@ -0,0 +1,53 @@
+package schema
+
+import (
+	"backend/database/ent/schema/mixin"
+
+	"entgo.io/ent"
+	"entgo.io/ent/dialect/entsql"
+	"entgo.io/ent/schema"
+	"entgo.io/ent/schema/edge"
+	"entgo.io/ent/schema/field"
+	"entgo.io/ent/schema/index"
+)
+
+// TemporaryFile records who uploaded a file to the temporary folder, so it
+// counts towards their storage quota until it is moved or cleaned up.
+type TemporaryFile struct {
+	ent.Schema
+}
+
+func (TemporaryFile) Annotations() []schema.Annotation {
+	return []schema.Annotation{
+		entsql.Annotation{Table: "temporary_file"},
+	}
+}
+
+func (TemporaryFile) Indexes() []ent.Index {
+	return []ent.Index{
+		index.Fields("userId"),
+	}
+}
+
+func (TemporaryFile) Mixin() []ent.Mixin {
+	return []ent.Mixin{
+		mixin.Timestamp{},
+	}
+}
+
+func (TemporaryFile) Fields() []ent.Field {
+	return []ent.Field{
+		field.String("name").Unique(),
+		field.Int64("size"),
+		field.String("mimeType").StorageKey("mime_type").Optional(),
+		field.Int("userId").StorageKey("user_id"),
+	}
+}
+
+func (TemporaryFile) Edges() []ent.Edge {
+	return []ent.Edge{
+		edge.From("user", User.Type).
+			Ref("temporaryFiles").Field("userId").
+			Unique().Required(),
+	}
+}
@@ -0,0 +1,54 @@
+package schema
+
+import (
+	"backend/database/ent/schema/mixin"
+
+	"entgo.io/ent"
+	"entgo.io/ent/dialect/entsql"
+	"entgo.io/ent/schema"
+	"entgo.io/ent/schema/edge"
+	"entgo.io/ent/schema/field"
+	"entgo.io/ent/schema/index"
+)
+
+// Upload is a resumable upload in progress. Received chunks are kept in
+// storage until the upload is completed into the temporary folder, or expires.
+type Upload struct {
+	ent.Schema
+}
+
+func (Upload) Annotations() []schema.Annotation {
+	return []schema.Annotation{
+		entsql.Annotation{Table: "upload"},
+	}
+}
+
+func (Upload) Indexes() []ent.Index {
+	return []ent.Index{
+		index.Fields("expiresAt"),
+	}
+}
+
+func (Upload) Mixin() []ent.Mixin {
+	return []ent.Mixin{
+		mixin.Timestamp{},
+	}
+}
+
+func (Upload) Fields() []ent.Field {
+	return []ent.Field{
+		field.String("name"),
+		field.Int64("size"),
+		field.Int64("receivedSize").StorageKey("received_size").Default(0),
+		field.Time("expiresAt").StorageKey("expires_at"),
+		field.Int("userId").StorageKey("user_id"),
+	}
+}
+
+func (Upload) Edges() []ent.Edge {
+	return []ent.Edge{
+		edge.From("user", User.Type).
+			Ref("uploads").Field("userId").
+			Unique().Required(),
+	}
+}
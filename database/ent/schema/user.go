@@ -25,6 +25,7 @@ func (User) Annotations() []schema.Annotation {
 func (User) Indexes() []ent.Index {
 	return []ent.Index{
 		index.Fields("email").Unique(),
+		index.Fields("username").Unique(),
 	}
 }
 
@@ -38,10 +39,22 @@ func (User) Fields() []ent.Field {
 	return []ent.Field{
 		field.String("fullname").MaxLen(50),
 		field.String("email").MaxLen(100),
+		field.String("username").MaxLen(30).Optional().Nillable(),
+		field.Time("usernameChangedAt").StorageKey("username_changed_at").Optional().Nillable(),
 		field.String("phone").MaxLen(50).Optional(),
 		field.String("avatar").Optional(),
+		field.String("cover").Optional(),
+		field.String("bio").MaxLen(500).Optional(),
+		field.String("statusText").StorageKey("status_text").MaxLen(100).Optional(),
+		field.String("statusEmoji").StorageKey("status_emoji").MaxLen(32).Optional(),
+		field.Time("statusExpiresAt").StorageKey("status_expires_at").Optional().Nillable(),
+		field.String("timezone").MaxLen(64).Optional(),
+		field.String("locale").MaxLen(35).Optional(),
 		field.Bool("isActive").StorageKey("is_active").Default(false),
-		field.Time("lastActiveAt").StorageKey("last_active_at").Default(time.Now),
+		field.Time("lastActiveAt").StorageKey("last_active_at").Default(time.Now).Nillable(),
+		field.Time("suspendedAt").StorageKey("suspended_at").Optional().Nillable(),
+		// storageQuota overrides the default storage quota in bytes
+		field.Int64("storageQuota").StorageKey("storage_quota").Optional().Nillable(),
 	}
 }
 
@@ -50,5 +63,17 @@ func (User) Edges() []ent.Edge {
 		edge.To("verificationCodes", VerificationCode.Type),
 		edge.To("conversationMembers", ConversationMember.Type),
 		edge.To("messages", Message.Type),
+		edge.To("forwardedMessages", Message.Type),
+		edge.To("blocks", UserBlock.Type),
+		edge.To("blockedBy", UserBlock.Type),
+		edge.To("mentions", MessageMention.Type),
+		edge.To("setting", UserSetting.Type).Unique(),
+		edge.To("pinnedMessages", PinnedMessage.Type),
+		edge.To("scheduledMessages", ScheduledMessage.Type),
+		edge.To("pollVotes", PollVote.Type),
+		edge.To("messageDeliveries", MessageDelivery.Type),
+		edge.To("drafts", Draft.Type),
+		edge.To("uploads", Upload.Type),
+		edge.To("temporaryFiles", TemporaryFile.Type),
 	}
 }
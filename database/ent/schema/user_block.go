@@ -0,0 +1,52 @@
+package schema
+
+import (
+	"backend/database/ent/schema/mixin"
+
+	"entgo.io/ent"
+	"entgo.io/ent/dialect/entsql"
+	"entgo.io/ent/schema"
+	"entgo.io/ent/schema/edge"
+	"entgo.io/ent/schema/field"
+	"entgo.io/ent/schema/index"
+)
+
+type UserBlock struct {
+	ent.Schema
+}
+
+func (UserBlock) Annotations() []schema.Annotation {
+	return []schema.Annotation{
+		entsql.Annotation{Table: "user_block"},
+	}
+}
+
+func (UserBlock) Indexes() []ent.Index {
+	return []ent.Index{
+		index.Fields("blockerId", "blockedId").Unique(),
+	}
+}
+
+func (UserBlock) Mixin() []ent.Mixin {
+	return []ent.Mixin{
+		mixin.Timestamp{},
+	}
+}
+
+func (UserBlock) Fields() []ent.Field {
+	return []ent.Field{
+		field.Int("blockerId").StorageKey("blocker_id"),
+		field.Int("blockedId").StorageKey("blocked_id"),
+	}
+}
+
+func (UserBlock) Edges() []ent.Edge {
+	return []ent.Edge{
+		edge.From("blocker", User.Type).
+			Ref("blocks").Field("blockerId").
+			Unique().Required(),
+		edge.From("blocked", User.Type).
+			Ref("blockedBy").Field("blockedId").
+			Unique().Required(),
+	}
+}
@@ -0,0 +1,58 @@
+package schema
+
+import (
+	"backend/database/ent/schema/enum"
+	"backend/database/ent/schema/mixin"
+
+	"entgo.io/ent"
+	"entgo.io/ent/dialect/entsql"
+	"entgo.io/ent/schema"
+	"entgo.io/ent/schema/edge"
+	"entgo.io/ent/schema/field"
+	"entgo.io/ent/schema/index"
+)
+
+type UserSetting struct {
+	ent.Schema
+}
+
+func (UserSetting) Annotations() []schema.Annotation {
+	return []schema.Annotation{
+		entsql.Annotation{Table: "user_setting"},
+	}
+}
+
+func (UserSetting) Indexes() []ent.Index {
+	return []ent.Index{
+		index.Fields("userId").Unique(),
+	}
+}
+
+func (UserSetting) Mixin() []ent.Mixin {
+	return []ent.Mixin{
+		mixin.Timestamp{},
+	}
+}
+
+func (UserSetting) Fields() []ent.Field {
+	return []ent.Field{
+		field.Enum("lastActiveVisibility").StorageKey("last_active_visibility").
+			GoType(enum.Visibility("")).Default(string(enum.VisibilityEveryone)),
+		field.Enum("onlineVisibility").StorageKey("online_visibility").
+			GoType(enum.Visibility("")).Default(string(enum.VisibilityEveryone)),
+		field.Enum("emailVisibility").StorageKey("email_visibility").
+			GoType(enum.Visibility("")).Default(string(enum.VisibilityEveryone)),
+		field.Enum("phoneVisibility").StorageKey("phone_visibility").
+			GoType(enum.Visibility("")).Default(string(enum.VisibilityContacts)),
+		field.Bool("sendReadReceipts").StorageKey("send_read_receipts").Default(true),
+		field.Int("userId").StorageKey("user_id"),
+	}
+}
+
+func (UserSetting) Edges() []ent.Edge {
+	return []ent.Edge{
+		edge.From("user", User.Type).
+			Ref("setting").Field("userId").
+			Unique().Required(),
+	}
+}
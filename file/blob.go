@@ -0,0 +1,158 @@
+package file
+
+import (
+	"backend/database/ent"
+	"backend/database/ent/blob"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+)
+
+// blobNamePattern matches the name of a blob or of one of its variants,
+// capturing the name without extension.
+var blobNamePattern = regexp.MustCompile(`^([0-9a-f]{64}(_w\d+)?)\.[a-z0-9]+$`)
+
+// ETag returns a strong entity tag for files named after their content and
+// their variants, whose content never changes. ok is false for other files.
+func ETag(filePath string) (etag string, ok bool) {
+	match := blobNamePattern.FindStringSubmatch(path.Base(filePath))
+	if match == nil {
+		return "", false
+	}
+	return `"` + match[1] + `"`, true
+}
+
+// storeBlob stores the content of sourcePath in folder under a name derived
+// from its SHA-256, so identical uploads share one stored file. The returned
+// path holds a reference on the blob, taken through client so that it is
+// rolled back with the caller's transaction, and released by Delete. The
+// source is left untouched.
+func (f *file) storeBlob(ctx context.Context, client *ent.Client, sourcePath, folder string) (string, error) {
+	// Stripped content is what gets stored, so it is what gets hashed
+	stripped, err := f.stripMetadata(sourcePath, folder)
+	if err != nil {
+		return "", err
+	}
+
+	var sum string
+	var size int64
+	if stripped != nil {
+		h := sha256.Sum256(stripped)
+		sum, size = hex.EncodeToString(h[:]), int64(len(stripped))
+	} else {
+		sum, size, err = f.hash(sourcePath)
+		if err != nil {
+			return "", err
+		}
+	}
+	blobPath := path.Join(folder, sum+strings.ToLower(path.Ext(sourcePath)))
+
+	ok, err := f.acquireBlob(ctx, client, blobPath)
+	if err != nil {
+		return "", err
+	}
+	if ok {
+		return blobPath, nil
+	}
+
+	// Identical content may be left over from a blob whose row is gone, it is
+	// overwritten with the same bytes
+	if stripped != nil {
+		err = f.storage.Save(blobPath, bytes.NewReader(stripped), size)
+	} else {
+		err = f.copyContent(sourcePath, blobPath, size)
+	}
+	if err != nil {
+		return "", errors.Errorf("could not store file: %w", err)
+	}
+
+	if err := f.generateVariants(blobPath); err != nil {
+		return "", err
+	}
+
+	// Stored concurrently by another upload of the same content, which waits
+	// for the first transaction to insert the row
+	err = client.Blob.Create().
+		SetKey(blobPath).
+		SetSize(size).
+		SetRefCount(1).
+		OnConflictColumns(blob.FieldKey).
+		AddRefCount(1).
+		Exec(ctx)
+	if err != nil {
+		return "", errors.Wrap(err, "Blob.Create failed")
+	}
+
+	return blobPath, nil
+}
+
+// acquireBlob adds a reference to an existing blob, reporting whether it
+// exists.
+func (f *file) acquireBlob(ctx context.Context, client *ent.Client, blobPath string) (bool, error) {
+	affected, err := client.Blob.Update().
+		Where(blob.Key(blobPath)).
+		AddRefCount(1).
+		Save(ctx)
+	if err != nil {
+		return false, errors.Wrap(err, "Blob.Update failed")
+	}
+	return affected > 0, nil
+}
+
+// releaseBlob drops a reference to a blob. It reports false for files not
+// stored as a blob.
+//
+// The stored file outlives its last reference, since the caller's
+// transaction may still roll back. The janitor deletes it once nothing
+// acquired it again for a grace period.
+func (f *file) releaseBlob(ctx context.Context, client *ent.Client, blobPath string) (bool, error) {
+	affected, err := client.Blob.Update().
+		Where(blob.Key(blobPath), blob.RefCountGT(0)).
+		AddRefCount(-1).
+		Save(ctx)
+	if err != nil {
+		return false, errors.Wrap(err, "Blob.Update failed")
+	}
+	if affected > 0 {
+		return true, nil
+	}
+
+	exists, err := client.Blob.Query().Where(blob.Key(blobPath)).Exist(ctx)
+	if err != nil {
+		return false, errors.Wrap(err, "Query failed")
+	}
+	return exists, nil
+}
+
+// hash returns the hex encoded SHA-256 and the size of a stored file.
+func (f *file) hash(filePath string) (string, int64, error) {
+	reader, err := f.Open(filePath)
+	if err != nil {
+		return "", 0, err
+	}
+	defer reader.Close()
+
+	h := sha256.New()
+	size, err := io.Copy(h, reader)
+	if err != nil {
+		return "", 0, errors.Errorf("could not read file content: %w", err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), size, nil
+}
+
+func (f *file) copyContent(sourcePath, destinationPath string, size int64) error {
+	source, err := f.Open(sourcePath)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	return f.storage.Save(destinationPath, source, size)
+}
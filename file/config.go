@@ -1,14 +1,21 @@
 package file
 
 const (
-	FolderUser         = "user"
-	FolderMessageMedia = "message_media"
+	FolderUser           = "user"
+	FolderMessageMedia   = "message_media"
+	FolderScheduledMedia = "scheduled_media"
+	FolderLinkPreview    = "link_preview"
 )
 
 var (
 	defaultAllowedImageExtensions = []string{".jpg", ".jpeg", ".png", ".gif", ".bmp", ".tif", ".tiff", ".webp", ".heic", ".heif", ".raw"}
 	defaultAllowedVideoExtensions = []string{".mp4", ".avi", ".mkv", ".mov", ".wmv", ".flv", ".webm", ".3gp", ".m4v", ".mpeg", ".mpg", ".ogv"}
 	defaultAllowedExtensions      = append(defaultAllowedImageExtensions, defaultAllowedVideoExtensions...)
+	defaultImageVariantWidths     = []int{320, 640, 1280}
+
+	// Images whose metadata can be stripped, or which carry none
+	strippedImageExtensions   = []string{".jpg", ".jpeg", ".png", ".gif", ".bmp", ".webp"}
+	strippedAllowedExtensions = append(strippedImageExtensions, defaultAllowedVideoExtensions...)
 )
 
 const (
@@ -16,11 +23,17 @@ const (
 	defaultMaxImageSizeBytes = 10 * 1024 * 1024  // 10 MB
 	clientRootPath           = "resources/clients"
 	temporaryFolderName      = "temporary"
+	uploadFolderName         = "upload"
+	pendingFolderName        = "pending"
 )
 
 type folderConfig struct {
 	allowedExtensions []string
 	maxSizeBytes      int64
+	variantWidths     []int // ascending
+	// stripMetadata removes EXIF (e.g. GPS location), XMP and IPTC metadata
+	// from images moved into the folder
+	stripMetadata bool
 }
 
 var folderConfigurations = map[string]folderConfig{
@@ -29,11 +42,24 @@ var folderConfigurations = map[string]folderConfig{
 		maxSizeBytes:      defaultMaxSizeBytes,
 	},
 	FolderUser: {
-		allowedExtensions: defaultAllowedImageExtensions,
+		allowedExtensions: strippedImageExtensions,
 		maxSizeBytes:      defaultMaxImageSizeBytes,
+		variantWidths:     defaultImageVariantWidths,
+		stripMetadata:     true,
 	},
 	FolderMessageMedia: {
-		allowedExtensions: defaultAllowedExtensions,
+		allowedExtensions: strippedAllowedExtensions,
+		maxSizeBytes:      defaultMaxSizeBytes,
+		variantWidths:     defaultImageVariantWidths,
+		stripMetadata:     true,
+	},
+	// Sent to FolderMessageMedia, it accepts the same files
+	FolderScheduledMedia: {
+		allowedExtensions: strippedAllowedExtensions,
 		maxSizeBytes:      defaultMaxSizeBytes,
 	},
+	FolderLinkPreview: {
+		allowedExtensions: defaultAllowedImageExtensions,
+		maxSizeBytes:      defaultMaxImageSizeBytes,
+	},
 }
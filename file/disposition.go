@@ -0,0 +1,28 @@
+package file
+
+import (
+	"mime"
+	"path"
+	"strings"
+)
+
+// contentDisposition returns the Content-Disposition servedPath is served
+// with, an attachment for downloads. It is named name, the file originally
+// uploaded, when known, with the extension of servedPath since a variant may
+// be of another format.
+func contentDisposition(name, servedPath string, download bool) string {
+	if name == "" {
+		name = path.Base(servedPath)
+	} else if ext := path.Ext(servedPath); !strings.EqualFold(path.Ext(name), ext) {
+		name = strings.TrimSuffix(name, path.Ext(name)) + ext
+	}
+
+	dispositionType := "inline"
+	if download {
+		dispositionType = "attachment"
+	}
+	if res := mime.FormatMediaType(dispositionType, map[string]string{"filename": name}); res != "" {
+		return res
+	}
+	return dispositionType
+}
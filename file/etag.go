@@ -0,0 +1,74 @@
+package file
+
+import (
+	"backend/database/ent"
+	"backend/database/ent/filehash"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+
+	"github.com/cockroachdb/errors"
+)
+
+// etag returns a strong entity tag of the content of a stored file, and
+// whether that content never changes. Files named after their content are
+// tagged by name, the SHA-256 of other files is computed when first served
+// and kept until they change.
+func etag(ctx context.Context, client *ent.Client, f File, filePath string, info *FileInfo) (string, bool, error) {
+	if tag, ok := ETag(filePath); ok {
+		return tag, true, nil
+	}
+
+	version := fmt.Sprintf("%x-%x", info.Size, info.ModTime.UnixNano())
+	cached, err := client.FileHash.
+		Query().
+		Where(filehash.Key(filePath)).
+		First(ctx)
+	if err != nil && !ent.IsNotFound(err) {
+		return "", false, errors.Wrap(err, "Query failed")
+	}
+	if cached != nil && cached.Version == version {
+		return `"` + cached.Hash + `"`, false, nil
+	}
+
+	reader, err := f.Open(filePath)
+	if err != nil {
+		return "", false, err
+	}
+	defer reader.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, reader); err != nil {
+		return "", false, errors.Errorf("could not read file content: %w", err)
+	}
+	sum := hex.EncodeToString(h.Sum(nil))
+
+	err = client.FileHash.Create().
+		SetKey(filePath).
+		SetHash(sum).
+		SetVersion(version).
+		OnConflictColumns(filehash.FieldKey).
+		UpdateNewValues().
+		Exec(ctx)
+	if err != nil {
+		return "", false, errors.Wrap(err, "FileHash.Create failed")
+	}
+
+	return `"` + sum + `"`, false, nil
+}
+
+// forgetHash deletes the hashes of a deleted file and of its variants.
+func forgetHash(ctx context.Context, client *ent.Client, filePath string) error {
+	keys := []string{filePath}
+	for _, width := range variantWidths(filePath) {
+		keys = append(keys, variantPath(filePath, width))
+	}
+
+	_, err := client.FileHash.Delete().Where(filehash.KeyIn(keys...)).Exec(ctx)
+	if err != nil {
+		return errors.Wrap(err, "FileHash.Delete failed")
+	}
+	return nil
+}
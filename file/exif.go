@@ -0,0 +1,365 @@
+package file
+
+import (
+	"backend/apperror"
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+)
+
+const (
+	// Quality of photos re-encoded to apply their orientation, higher than
+	// variants since they replace the original.
+	orientedJpegQuality = 92
+	exifOrientationTag  = 0x0112
+)
+
+var (
+	exifHeader   = []byte("Exif\x00\x00")
+	pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+	// Chunks of a PNG holding metadata rather than pixels
+	pngMetadataChunks = []string{"eXIf", "tEXt", "zTXt", "iTXt", "tIME"}
+)
+
+// stripMetadata returns the content of the image at filePath without its
+// EXIF (location, camera, ...), XMP and IPTC metadata, or nil when there is
+// nothing to strip or the folder keeps metadata. Photos turned through their
+// EXIF orientation are re-encoded upright, except WebP which keeps the
+// orientation alone since it cannot be encoded. An image that cannot be
+// stripped is rejected rather than stored with its metadata.
+func (f *file) stripMetadata(filePath, folder string) ([]byte, error) {
+	if !folderConfigurations[folder].stripMetadata {
+		return nil, nil
+	}
+
+	var strip func([]byte) ([]byte, error)
+	switch strings.ToLower(path.Ext(filePath)) {
+	case ".jpg", ".jpeg":
+		strip = stripJpeg
+	case ".png":
+		strip = stripPng
+	case ".webp":
+		strip = stripWebp
+	default:
+		// GIF and BMP have no EXIF, other images are not allowed in folders
+		// stripping metadata
+		return nil, nil
+	}
+
+	reader, err := f.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	content, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, errors.Errorf("could not read file content: %w", err)
+	}
+
+	res, err := strip(content)
+	if err != nil {
+		return nil, apperror.BadRequest(
+			errors.Errorf("%w: %s: %v", ErrMetadataNotStripped, path.Base(filePath), err).Error(),
+			nil, nil,
+		)
+	}
+	return res, nil
+}
+
+// stripJpeg drops the APP1 (EXIF, XMP), APP13 (IPTC) and comment segments of
+// a JPEG. Color profiles (APP2) and Adobe color transforms (APP14) are kept.
+func stripJpeg(content []byte) ([]byte, error) {
+	if len(content) < 2 || content[0] != 0xFF || content[1] != 0xD8 {
+		return nil, errors.New("not a JPEG")
+	}
+
+	res := bytes.NewBuffer(make([]byte, 0, len(content)))
+	res.Write(content[:2])
+	stripped := false
+	orientation := 1
+
+	for i := 2; ; {
+		// Markers may be preceded by fill bytes
+		for i < len(content) && content[i] == 0xFF && i+1 < len(content) && content[i+1] == 0xFF {
+			i++
+		}
+		if i+4 > len(content) || content[i] != 0xFF {
+			return nil, errors.New("malformed JPEG segment")
+		}
+		marker := content[i+1]
+
+		// Start of scan, the entropy coded data follows up to the end
+		if marker == 0xDA {
+			res.Write(content[i:])
+			break
+		}
+
+		length := int(binary.BigEndian.Uint16(content[i+2:]))
+		end := i + 2 + length
+		if length < 2 || end > len(content) {
+			return nil, errors.New("malformed JPEG segment")
+		}
+		segment := content[i:end]
+		i = end
+
+		switch marker {
+		case 0xE1:
+			if payload := segment[4:]; bytes.HasPrefix(payload, exifHeader) {
+				orientation = exifOrientation(payload[len(exifHeader):])
+			}
+			stripped = true
+		case 0xED, 0xFE:
+			stripped = true
+		default:
+			res.Write(segment)
+		}
+	}
+
+	if orientation != 1 {
+		return reorient(content, orientation, func(w io.Writer, m image.Image) error {
+			return jpeg.Encode(w, m, &jpeg.Options{Quality: orientedJpegQuality})
+		})
+	}
+	if !stripped {
+		return nil, nil
+	}
+	return res.Bytes(), nil
+}
+
+// stripPng drops the eXIf and text chunks of a PNG.
+func stripPng(content []byte) ([]byte, error) {
+	if !bytes.HasPrefix(content, pngSignature) {
+		return nil, errors.New("not a PNG")
+	}
+
+	res := bytes.NewBuffer(make([]byte, 0, len(content)))
+	res.Write(pngSignature)
+	stripped := false
+	orientation := 1
+
+	for i := len(pngSignature); i < len(content); {
+		if i+12 > len(content) {
+			return nil, errors.New("malformed PNG chunk")
+		}
+		length := int(binary.BigEndian.Uint32(content[i:]))
+		end := i + 12 + length
+		if end > len(content) {
+			return nil, errors.New("malformed PNG chunk")
+		}
+		chunk := content[i:end]
+		chunkType := string(chunk[4:8])
+		i = end
+
+		if chunkType == "eXIf" {
+			orientation = exifOrientation(chunk[8 : 8+length])
+		}
+		if isPngMetadataChunk(chunkType) {
+			stripped = true
+			continue
+		}
+		res.Write(chunk)
+	}
+
+	if orientation != 1 {
+		return reorient(content, orientation, png.Encode)
+	}
+	if !stripped {
+		return nil, nil
+	}
+	return res.Bytes(), nil
+}
+
+func isPngMetadataChunk(chunkType string) bool {
+	for _, t := range pngMetadataChunks {
+		if t == chunkType {
+			return true
+		}
+	}
+	return false
+}
+
+// stripWebp drops the XMP chunk of an extended WebP and replaces its EXIF
+// chunk with one holding the orientation alone.
+func stripWebp(content []byte) ([]byte, error) {
+	if len(content) < 12 || string(content[:4]) != "RIFF" || string(content[8:12]) != "WEBP" {
+		return nil, errors.New("not a WebP")
+	}
+
+	var chunks [][]byte
+	stripped := false
+	for i := 12; i < len(content); {
+		if i+8 > len(content) {
+			return nil, errors.New("malformed WebP chunk")
+		}
+		size := int(binary.LittleEndian.Uint32(content[i+4:]))
+		end := i + 8 + size + size%2
+		if end > len(content) {
+			return nil, errors.New("malformed WebP chunk")
+		}
+		chunk := content[i:end]
+		i = end
+
+		switch string(chunk[:4]) {
+		case "XMP ":
+			stripped = true
+		case "EXIF":
+			stripped = true
+			if orientation := exifOrientation(bytes.TrimPrefix(chunk[8:8+size], exifHeader)); orientation != 1 {
+				chunks = append(chunks, webpOrientationChunk(orientation))
+			}
+		default:
+			chunks = append(chunks, chunk)
+		}
+	}
+	if !stripped {
+		return nil, nil
+	}
+
+	hasExif := false
+	for _, chunk := range chunks {
+		hasExif = hasExif || string(chunk[:4]) == "EXIF"
+	}
+
+	res := bytes.NewBuffer(make([]byte, 0, len(content)))
+	res.WriteString("RIFF")
+	res.Write(make([]byte, 4)) // size, set once known
+	res.WriteString("WEBP")
+	for _, chunk := range chunks {
+		if string(chunk[:4]) == "VP8X" && len(chunk) >= 9 {
+			// Flags of the extended header announce which metadata follows
+			chunk = bytes.Clone(chunk)
+			chunk[8] &^= 0x04 // XMP
+			if !hasExif {
+				chunk[8] &^= 0x08 // EXIF
+			}
+		}
+		res.Write(chunk)
+	}
+
+	out := res.Bytes()
+	binary.LittleEndian.PutUint32(out[4:], uint32(len(out)-8))
+	return out, nil
+}
+
+// webpOrientationChunk is an EXIF chunk holding the orientation tag alone.
+func webpOrientationChunk(orientation int) []byte {
+	tiff := []byte{
+		'I', 'I', 0x2A, 0x00, 0x08, 0x00, 0x00, 0x00, // header, IFD0 at offset 8
+		0x01, 0x00, // one entry
+		0x12, 0x01, 0x03, 0x00, 0x01, 0x00, 0x00, 0x00, byte(orientation), 0x00, 0x00, 0x00, // orientation, one SHORT
+		0x00, 0x00, 0x00, 0x00, // no next IFD
+	}
+
+	chunk := []byte("EXIF")
+	chunk = binary.LittleEndian.AppendUint32(chunk, uint32(len(tiff)))
+	return append(chunk, tiff...)
+}
+
+// exifOrientation reads the orientation tag from the first IFD of TIFF
+// formatted EXIF data, 1 (upright) when absent or invalid.
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+
+	offset := int(order.Uint32(tiff[4:]))
+	if offset < 8 || offset+2 > len(tiff) {
+		return 1
+	}
+	count := int(order.Uint16(tiff[offset:]))
+	for i := 0; i < count; i++ {
+		entry := offset + 2 + i*12
+		if entry+12 > len(tiff) {
+			return 1
+		}
+		if order.Uint16(tiff[entry:]) != exifOrientationTag {
+			continue
+		}
+		if value := int(order.Uint16(tiff[entry+8:])); value >= 1 && value <= 8 {
+			return value
+		}
+		return 1
+	}
+	return 1
+}
+
+// reorient decodes an image and encodes it again turned upright according to
+// its EXIF orientation, which leaves all metadata behind.
+func reorient(content []byte, orientation int, encode func(io.Writer, image.Image) error) ([]byte, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(content))
+	if err != nil {
+		return nil, err
+	}
+	if config.Width*config.Height > maxImagePixels {
+		return nil, errors.New("image too large to decode")
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(content))
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := encode(&buf, applyOrientation(src, orientation)); err != nil {
+		return nil, errors.Errorf("could not encode image: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// applyOrientation flips and rotates src as described by an EXIF orientation
+// value (2 to 8).
+func applyOrientation(src image.Image, orientation int) image.Image {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, dw, dh))
+
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			var sx, sy int
+			switch orientation {
+			case 2:
+				sx, sy = w-1-x, y
+			case 3:
+				sx, sy = w-1-x, h-1-y
+			case 4:
+				sx, sy = x, h-1-y
+			case 5:
+				sx, sy = y, x
+			case 6:
+				sx, sy = y, h-1-x
+			case 7:
+				sx, sy = w-1-y, h-1-x
+			case 8:
+				sx, sy = w-1-y, x
+			default:
+				sx, sy = x, y
+			}
+			dst.Set(x, y, src.At(b.Min.X+sx, b.Min.Y+sy))
+		}
+	}
+	return dst
+}
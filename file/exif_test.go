@@ -0,0 +1,335 @@
+package file
+
+import (
+	"backend/apperror"
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"path"
+	"testing"
+
+	"github.com/cockroachdb/errors"
+)
+
+// gpsMarker is the GPS map datum of the fixtures, found in any output that
+// still carries their location.
+const gpsMarker = "GPS-FIXTURE-DATUM"
+
+// webpPixel is a lossless 1x1 WebP bitstream.
+var webpPixel = []byte("\x2f\x00\x00\x00\x10\x07\x10\x11\x11\x88\x88\xfe\x07\x00")
+
+// exifWithGps is TIFF formatted EXIF data holding an orientation and a GPS
+// IFD.
+func exifWithGps(orientation int) []byte {
+	le := binary.LittleEndian
+	tiff := []byte{'I', 'I', 0x2A, 0x00}
+	tiff = le.AppendUint32(tiff, 8)
+
+	// IFD0: orientation and the GPS IFD at offset 38
+	tiff = le.AppendUint16(tiff, 2)
+	tiff = appendIfdEntry(tiff, exifOrientationTag, 3, 1, uint32(orientation))
+	tiff = appendIfdEntry(tiff, 0x8825, 4, 1, 38)
+	tiff = le.AppendUint32(tiff, 0)
+
+	// GPS IFD: latitude reference and the map datum at offset 56
+	tiff = le.AppendUint16(tiff, 2)
+	tiff = appendIfdEntry(tiff, 0x0001, 2, 2, 'N')
+	tiff = appendIfdEntry(tiff, 0x0012, 2, uint32(len(gpsMarker)+1), 56)
+	tiff = le.AppendUint32(tiff, 0)
+
+	return append(append(tiff, gpsMarker...), 0)
+}
+
+func appendIfdEntry(tiff []byte, tag, kind uint16, count, value uint32) []byte {
+	le := binary.LittleEndian
+	tiff = le.AppendUint16(tiff, tag)
+	tiff = le.AppendUint16(tiff, kind)
+	tiff = le.AppendUint32(tiff, count)
+	return le.AppendUint32(tiff, value)
+}
+
+// halves is a w x h image, red on its left half and blue on its right half.
+func halves(w, h int) image.Image {
+	m := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			if x < w/2 {
+				m.Set(x, y, color.RGBA{R: 255, A: 255})
+			} else {
+				m.Set(x, y, color.RGBA{B: 255, A: 255})
+			}
+		}
+	}
+	return m
+}
+
+// jpegWithGps is a JPEG carrying EXIF with GPS, XMP, IPTC and a comment.
+func jpegWithGps(t *testing.T, m image.Image, orientation int) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, m, &jpeg.Options{Quality: 100}); err != nil {
+		t.Fatal(err)
+	}
+	content := buf.Bytes()
+
+	segment := func(marker byte, payload []byte) []byte {
+		res := []byte{0xFF, marker}
+		res = binary.BigEndian.AppendUint16(res, uint16(len(payload)+2))
+		return append(res, payload...)
+	}
+
+	res := append([]byte{}, content[:2]...)
+	res = append(res, segment(0xE1, append(append([]byte{}, exifHeader...), exifWithGps(orientation)...))...)
+	res = append(res, segment(0xE1, []byte("http://ns.adobe.com/xap/1.0/\x00<x:xmpmeta>"+gpsMarker+"</x:xmpmeta>"))...)
+	res = append(res, segment(0xED, []byte("Photoshop 3.0\x00"+gpsMarker))...)
+	res = append(res, segment(0xFE, []byte(gpsMarker))...)
+	return append(res, content[2:]...)
+}
+
+// pngWithGps is a PNG carrying an eXIf chunk with GPS and a text chunk.
+func pngWithGps(t *testing.T, orientation int) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, halves(16, 8)); err != nil {
+		t.Fatal(err)
+	}
+	content := buf.Bytes()
+
+	chunk := func(chunkType string, data []byte) []byte {
+		res := binary.BigEndian.AppendUint32(nil, uint32(len(data)))
+		res = append(res, chunkType...)
+		res = append(res, data...)
+		return binary.BigEndian.AppendUint32(res, crc32.ChecksumIEEE(res[4:]))
+	}
+
+	// Metadata goes right after IHDR
+	ihdrEnd := len(pngSignature) + 8 + 13 + 4
+	res := append([]byte{}, content[:ihdrEnd]...)
+	res = append(res, chunk("eXIf", exifWithGps(orientation))...)
+	res = append(res, chunk("tEXt", []byte("Comment\x00"+gpsMarker))...)
+	return append(res, content[ihdrEnd:]...)
+}
+
+// webpWithGps is an extended WebP carrying EXIF with GPS and XMP.
+func webpWithGps(orientation int) []byte {
+	chunk := func(chunkType string, data []byte) []byte {
+		res := append([]byte(chunkType), binary.LittleEndian.AppendUint32(nil, uint32(len(data)))...)
+		res = append(res, data...)
+		if len(data)%2 == 1 {
+			res = append(res, 0)
+		}
+		return res
+	}
+
+	// VP8X: EXIF and XMP flags, 1x1 canvas
+	vp8x := []byte{0x08 | 0x04, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+
+	body := []byte("WEBP")
+	body = append(body, chunk("VP8X", vp8x)...)
+	body = append(body, chunk("VP8L", webpPixel)...)
+	body = append(body, chunk("EXIF", append(append([]byte{}, exifHeader...), exifWithGps(orientation)...))...)
+	body = append(body, chunk("XMP ", []byte("<x:xmpmeta>"+gpsMarker+"</x:xmpmeta>"))...)
+
+	res := append([]byte("RIFF"), binary.LittleEndian.AppendUint32(nil, uint32(len(body)))...)
+	return append(res, body...)
+}
+
+func stripFixture(t *testing.T, name string, content []byte) ([]byte, error) {
+	t.Helper()
+
+	storage := newLocalStorage(t.TempDir())
+	filePath := path.Join(temporaryFolderName, name)
+	if err := storage.Save(filePath, bytes.NewReader(content), int64(len(content))); err != nil {
+		t.Fatal(err)
+	}
+
+	f := &file{storage: storage}
+	return f.stripMetadata(filePath, FolderMessageMedia)
+}
+
+func assertNoMetadata(t *testing.T, content []byte) {
+	t.Helper()
+	if content == nil {
+		t.Fatal("metadata was not stripped")
+	}
+	if bytes.Contains(content, []byte(gpsMarker)) {
+		t.Fatal("GPS metadata left in stripped image")
+	}
+}
+
+func assertColor(t *testing.T, m image.Image, x, y int, red bool) {
+	t.Helper()
+	r, _, b, _ := m.At(x, y).RGBA()
+	if (r > b) != red {
+		t.Fatalf("unexpected color at (%d, %d): %v", x, y, m.At(x, y))
+	}
+}
+
+func TestStripMetadataJpeg(t *testing.T) {
+	res, err := stripFixture(t, "photo.jpg", jpegWithGps(t, halves(16, 8), 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertNoMetadata(t, res)
+	if bytes.Contains(res, exifHeader) {
+		t.Fatal("EXIF left in stripped image")
+	}
+
+	m, err := jpeg.Decode(bytes.NewReader(res))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Bounds().Dx() != 16 || m.Bounds().Dy() != 8 {
+		t.Fatalf("unexpected size %v", m.Bounds())
+	}
+}
+
+func TestStripMetadataOrientedJpeg(t *testing.T) {
+	// Orientation 6: stored turned counterclockwise, shown turned clockwise
+	res, err := stripFixture(t, "photo.jpg", jpegWithGps(t, halves(32, 16), 6))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertNoMetadata(t, res)
+
+	m, err := jpeg.Decode(bytes.NewReader(res))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Bounds().Dx() != 16 || m.Bounds().Dy() != 32 {
+		t.Fatalf("unexpected size %v", m.Bounds())
+	}
+	// The left half ends up on top
+	assertColor(t, m, 8, 4, true)
+	assertColor(t, m, 8, 28, false)
+}
+
+func TestStripMetadataPng(t *testing.T) {
+	res, err := stripFixture(t, "image.png", pngWithGps(t, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertNoMetadata(t, res)
+	if bytes.Contains(res, []byte("eXIf")) {
+		t.Fatal("eXIf chunk left in stripped image")
+	}
+
+	m, err := png.Decode(bytes.NewReader(res))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertColor(t, m, 2, 4, true)
+}
+
+func TestStripMetadataOrientedPng(t *testing.T) {
+	// Orientation 8: stored turned clockwise, shown turned counterclockwise
+	res, err := stripFixture(t, "image.png", pngWithGps(t, 8))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertNoMetadata(t, res)
+
+	m, err := png.Decode(bytes.NewReader(res))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Bounds().Dx() != 8 || m.Bounds().Dy() != 16 {
+		t.Fatalf("unexpected size %v", m.Bounds())
+	}
+	// The left half ends up at the bottom
+	assertColor(t, m, 4, 2, false)
+	assertColor(t, m, 4, 14, true)
+}
+
+func TestStripMetadataWebp(t *testing.T) {
+	res, err := stripFixture(t, "image.webp", webpWithGps(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertNoMetadata(t, res)
+	if bytes.Contains(res, []byte("EXIF")) || bytes.Contains(res, []byte("XMP ")) {
+		t.Fatal("metadata chunk left in stripped image")
+	}
+	if size := binary.LittleEndian.Uint32(res[4:]); int(size) != len(res)-8 {
+		t.Fatalf("RIFF size %d, want %d", size, len(res)-8)
+	}
+	if flags := res[20]; flags&(0x08|0x04) != 0 {
+		t.Fatalf("VP8X still announces metadata: %#x", flags)
+	}
+
+	if _, _, err := image.Decode(bytes.NewReader(res)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStripMetadataOrientedWebp(t *testing.T) {
+	res, err := stripFixture(t, "image.webp", webpWithGps(6))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertNoMetadata(t, res)
+
+	// The orientation alone is kept
+	i := bytes.Index(res, []byte("EXIF"))
+	if i < 0 {
+		t.Fatal("orientation was dropped")
+	}
+	size := int(binary.LittleEndian.Uint32(res[i+4:]))
+	if orientation := exifOrientation(res[i+8 : i+8+size]); orientation != 6 {
+		t.Fatalf("orientation %d, want 6", orientation)
+	}
+	if flags := res[20]; flags&0x08 == 0 || flags&0x04 != 0 {
+		t.Fatalf("unexpected VP8X flags %#x", flags)
+	}
+}
+
+func TestStripMetadataRejectsMalformed(t *testing.T) {
+	content := jpegWithGps(t, halves(16, 8), 1)
+	// Length of the EXIF segment running past the end of the file
+	binary.BigEndian.PutUint16(content[4:], 0xFFFF)
+
+	_, err := stripFixture(t, "photo.jpg", content[:200])
+	assertRejected(t, err)
+}
+
+func TestStripMetadataRejectsTooLargeToReorient(t *testing.T) {
+	content := jpegWithGps(t, halves(16, 8), 6)
+	// Frame header claiming 10000x10000 pixels
+	i := bytes.Index(content, []byte{0xFF, 0xC0})
+	if i < 0 {
+		t.Fatal("no frame header")
+	}
+	binary.BigEndian.PutUint16(content[i+5:], 10000)
+	binary.BigEndian.PutUint16(content[i+7:], 10000)
+
+	_, err := stripFixture(t, "photo.jpg", content)
+	assertRejected(t, err)
+}
+
+func assertRejected(t *testing.T, err error) {
+	t.Helper()
+	var appErr *apperror.AppError
+	if !errors.As(err, &appErr) {
+		t.Fatalf("got %v, want the upload rejected", err)
+	}
+}
+
+func TestMetadataFoldersRejectUnstrippedImages(t *testing.T) {
+	f := &file{}
+	for _, folder := range []string{FolderUser, FolderMessageMedia, FolderScheduledMedia} {
+		for _, name := range []string{"photo.heic", "photo.HEIF", "scan.tif", "scan.tiff", "photo.raw"} {
+			if err := f.validateFileProperties(1024, name, folder); err == nil {
+				t.Errorf("%s accepted in %s", name, folder)
+			}
+		}
+		if err := f.validateFileProperties(1024, "photo.jpg", folder); err != nil {
+			t.Errorf("photo.jpg rejected in %s: %v", folder, err)
+		}
+	}
+}
@@ -2,10 +2,14 @@ package file
 
 import (
 	"backend/apperror"
+	"backend/config"
+	"backend/database/ent"
+	"backend/database/ent/temporaryfile"
+	"backend/logger"
+	"context"
 	"fmt"
 	"io"
 	"mime/multipart"
-	"os"
 	"path"
 	"path/filepath"
 	"strings"
@@ -13,6 +17,8 @@ import (
 
 	"github.com/cockroachdb/errors"
 	"github.com/google/uuid"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
 )
 
 var (
@@ -21,24 +27,51 @@ var (
 	ErrSourceNotExist      = errors.New("Source file does not exist")
 	ErrDestinationExists   = errors.New("Destination file already exists")
 	ErrConfigNotFound      = errors.New("Configuration for folder not found")
+	ErrMetadataNotStripped = errors.New("Image metadata could not be removed")
 )
 
 type File interface {
 	Save(file multipart.File, fileHeader *multipart.FileHeader, fileNameWithoutExtension, folderName string) (string, error)
 	SaveToTemporary(file multipart.File, fileHeader *multipart.FileHeader) (string, error)
+	SaveReaderToTemporary(reader io.Reader, fileName string, size int64) (string, error)
+	ValidateTemporary(fileName string, size int64) error
+	SaveReader(reader io.Reader, extension, folderName string) (string, error)
 	Move(fileName, newFileNameWithoutExtension, sourceFolder, destinationFolder string) (string, error)
-	MoveFromTemporary(fileName, destinationFolder string) (string, error)
-	MoveFromTemporaryAndDeleteOldFile(fileName, destinationFolder, oldFilePath string) (string, error)
-	CleanTemporaryFiles(hours int) error
-	Delete(filePath string) error
-	GetFilePath(subPath, fileName string) (string, error)
+	MoveFromTemporary(ctx context.Context, client *ent.Client, fileName, destinationFolder string) (string, error)
+	MoveFromTemporaryAndDeleteOldFile(ctx context.Context, client *ent.Client, fileName, destinationFolder, oldFilePath string) (string, error)
+	Copy(ctx context.Context, client *ent.Client, filePath, destinationFolder string) (string, error)
+	CleanTemporaryFiles(hours int, keep func(fileName string) bool, dryRun bool) ([]string, error)
+	Delete(ctx context.Context, client *ent.Client, filePath string) error
+	Open(filePath string) (io.ReadCloser, error)
+	Stat(filePath string) (*FileInfo, error)
+	URL(filePath, contentDisposition string) (string, error)
+	Inspect(filePath string) (*Metadata, error)
+	Variant(filePath string, width int) string
+	Sign(filePath, name string) string
+	Verify(filePath, name string, expires int64, signature string) bool
 }
 
 type file struct {
+	storage      Storage
+	logger       *logger.Logger
+	urlSecretKey []byte
+	urlExpiresIn time.Duration
 }
 
-func newFile() File {
-	return &file{}
+type fileParams struct {
+	fx.In
+	Storage Storage
+	Logger  *logger.Logger
+	Env     *config.Env
+}
+
+func newFile(p fileParams) File {
+	return &file{
+		storage:      p.Storage,
+		logger:       p.Logger,
+		urlSecretKey: []byte(p.Env.FileUrlSecretKey),
+		urlExpiresIn: p.Env.FileUrlExpiresIn,
+	}
 }
 
 func (f *file) validateFileProperties(size int64, filename, folderName string) error {
@@ -77,148 +110,289 @@ func (f *file) validateFileProperties(size int64, filename, folderName string) e
 
 func (f *file) Save(file multipart.File, fileHeader *multipart.FileHeader, fileNameWithoutExtension, folderName string) (string, error) {
 	defer file.Close()
+	return f.save(file, fileHeader.Size, fileHeader.Filename, fileNameWithoutExtension, folderName)
+}
 
+func (f *file) save(reader io.Reader, size int64, fileName, fileNameWithoutExtension, folderName string) (string, error) {
 	// Validate the uploaded file using its header.
-	if err := f.validateFileProperties(fileHeader.Size, fileHeader.Filename, folderName); err != nil {
+	if err := f.validateFileProperties(size, fileName, folderName); err != nil {
 		return "", err
 	}
 
-	folderPath := filepath.Join(clientRootPath, folderName)
-	if err := os.MkdirAll(folderPath, os.ModePerm); err != nil {
-		return "", errors.Errorf("could not create directory: %w", err)
+	// The extension is chosen by the client, check the content agrees
+	_, content, err := sniff(reader, fileName)
+	if err != nil {
+		return "", err
 	}
 
 	if fileNameWithoutExtension == "" {
 		fileNameWithoutExtension = uuid.NewString()
 	}
-	newFileName := fmt.Sprintf("%s%s", fileNameWithoutExtension, strings.ToLower(filepath.Ext(fileHeader.Filename)))
-	filePath := filepath.Join(folderPath, newFileName)
+	newFileName := fmt.Sprintf("%s%s", fileNameWithoutExtension, strings.ToLower(filepath.Ext(fileName)))
 
-	dst, err := os.Create(filePath)
-	if err != nil {
-		return "", errors.Errorf("could not create destination file: %w", err)
-	}
-	defer dst.Close()
-
-	if _, err := io.Copy(dst, file); err != nil {
-		return "", errors.Errorf("could not save file content: %w", err)
+	if err := f.storage.Save(path.Join(folderName, newFileName), content, size); err != nil {
+		return "", err
 	}
 
 	return newFileName, nil
 }
 
 func (f *file) SaveToTemporary(file multipart.File, fileHeader *multipart.FileHeader) (string, error) {
+	defer file.Close()
+	return f.SaveReaderToTemporary(file, fileHeader.Filename, fileHeader.Size)
+}
+
+// SaveReaderToTemporary is SaveToTemporary for content that does not come
+// from a multipart form, e.g. assembled from resumable upload chunks.
+func (f *file) SaveReaderToTemporary(reader io.Reader, fileName string, size int64) (string, error) {
 	fileNameWithoutExtension := fmt.Sprintf("%s_%d", uuid.NewString(), time.Now().Unix())
-	return f.Save(file, fileHeader, fileNameWithoutExtension, temporaryFolderName)
+	return f.save(reader, size, fileName, fileNameWithoutExtension, temporaryFolderName)
+}
+
+// ValidateTemporary checks a file of the given name and size would be
+// accepted by SaveToTemporary, before its content is received.
+func (f *file) ValidateTemporary(fileName string, size int64) error {
+	return f.validateFileProperties(size, fileName, temporaryFolderName)
 }
 
-func (f *file) Delete(filePath string) error {
+// SaveReader stores content that does not come from an upload (e.g. a
+// downloaded image) under a new name. The size limit of the folder is enforced
+// while copying since the size is not known up front.
+func (f *file) SaveReader(reader io.Reader, extension, folderName string) (string, error) {
+	newFileName := fmt.Sprintf("%s%s", uuid.NewString(), strings.ToLower(extension))
+	if err := f.validateFileProperties(0, newFileName, folderName); err != nil {
+		return "", err
+	}
+
+	maxSizeBytes := folderConfigurations[strings.SplitN(folderName, "/", 2)[0]].maxSizeBytes
+	limited := &countingReader{reader: io.LimitReader(reader, maxSizeBytes+1)}
+	_, content, err := sniff(limited, newFileName)
+	if err != nil {
+		return "", err
+	}
+
+	filePath := path.Join(folderName, newFileName)
+	if err := f.storage.Save(filePath, content, -1); err != nil {
+		return "", err
+	}
+	if limited.count > maxSizeBytes {
+		f.storage.Delete(filePath)
+		return "", errors.Errorf("could not save file content: %w: limit for folder '%s' is %d MB", ErrFileTooLarge, folderName, maxSizeBytes/1024/1024)
+	}
+
+	return filePath, nil
+}
+
+// Delete releases a reference to a stored file through client, along with
+// the caller's transaction. Files of the folders referenced from the
+// database are left to the janitor, which deletes them once nothing
+// references them, other files are deleted right away.
+func (f *file) Delete(ctx context.Context, client *ent.Client, filePath string) error {
 	if filePath == "" {
 		return nil
 	}
-	fullPath := filepath.Join(clientRootPath, filePath)
-	if err := os.Remove(fullPath); err != nil && !os.IsNotExist(err) {
-		return errors.Errorf("could not delete file: %w", err)
+	found, err := f.releaseBlob(ctx, client, filePath)
+	if err != nil || found {
+		return err
 	}
-	return nil
+	for _, folder := range referencedFolders {
+		if path.Dir(filePath) == folder {
+			return nil
+		}
+	}
+	if err := f.deleteStored(filePath); err != nil {
+		return err
+	}
+	return forgetHash(ctx, client, filePath)
+}
+
+func (f *file) deleteStored(filePath string) error {
+	if err := f.deleteVariants(filePath); err != nil {
+		return err
+	}
+	return f.storage.Delete(filePath)
 }
 
 func (f *file) Move(fileName, newFileNameWithoutExtension, sourceFolder, destinationFolder string) (string, error) {
-	sourcePath := filepath.Join(clientRootPath, sourceFolder, fileName)
+	sourcePath := path.Join(sourceFolder, fileName)
 
-	sourceFileInfo, err := os.Stat(sourcePath)
+	sourceFileInfo, err := f.storage.Stat(sourcePath)
 	if err != nil {
-		if os.IsNotExist(err) {
+		if errors.Is(err, ErrNotExist) {
 			return "", apperror.BadRequest(errors.Errorf("%w: %s", ErrSourceNotExist, fileName).Error(), nil, nil)
 		}
 		return "", errors.Errorf("could not get source file info: %w", err)
 	}
 
 	// Re-validate the existing file against the destination folder's rules.
-	if err := f.validateFileProperties(sourceFileInfo.Size(), sourceFileInfo.Name(), destinationFolder); err != nil {
+	if err := f.validateFileProperties(sourceFileInfo.Size, fileName, destinationFolder); err != nil {
 		return "", err
 	}
 
-	destinationFolderPath := filepath.Join(clientRootPath, destinationFolder)
-	if err := os.MkdirAll(destinationFolderPath, os.ModePerm); err != nil {
-		return "", errors.Errorf("could not create destination directory: %w", err)
-	}
-
 	newFileName := fmt.Sprintf("%s%s", newFileNameWithoutExtension, strings.ToLower(filepath.Ext(fileName)))
-	destinationPath := filepath.Join(destinationFolderPath, newFileName)
+	destinationPath := path.Join(destinationFolder, newFileName)
 
-	if _, err := os.Stat(destinationPath); !os.IsNotExist(err) {
+	if _, err := f.storage.Stat(destinationPath); !errors.Is(err, ErrNotExist) {
 		return "", errors.Errorf("%w: %s", ErrDestinationExists, newFileName)
 	}
 
-	if err := os.Rename(sourcePath, destinationPath); err != nil {
+	if err := f.storage.Move(sourcePath, destinationPath); err != nil {
 		return "", errors.Errorf("could not move file: %w", err)
 	}
 
-	return path.Join(destinationFolder, newFileName), nil
+	if err := f.generateVariants(destinationPath); err != nil {
+		return "", err
+	}
+
+	return destinationPath, nil
 }
 
-func (f *file) MoveFromTemporary(fileName, destinationFolder string) (string, error) {
-	newFileNameWithoutExtension := uuid.NewString()
-	return f.Move(fileName, newFileNameWithoutExtension, temporaryFolderName, destinationFolder)
+// MoveFromTemporary stores an upload in destinationFolder, named after its
+// content so that identical files are stored once. The upload is no longer
+// tracked once the caller's transaction commits, its temporary copy is left
+// for the janitor so that a rolled back transaction can use it again.
+func (f *file) MoveFromTemporary(ctx context.Context, client *ent.Client, fileName, destinationFolder string) (string, error) {
+	sourcePath := path.Join(temporaryFolderName, fileName)
+
+	sourceFileInfo, err := f.Stat(sourcePath)
+	if err != nil {
+		return "", err
+	}
+
+	// Re-validate the existing file against the destination folder's rules.
+	if err := f.validateFileProperties(sourceFileInfo.Size, fileName, destinationFolder); err != nil {
+		return "", err
+	}
+
+	filePath, err := f.storeBlob(ctx, client, sourcePath, destinationFolder)
+	if err != nil {
+		return "", err
+	}
+
+	// No longer counted as a temporary file of its uploader
+	_, err = client.TemporaryFile.Delete().
+		Where(temporaryfile.Name(fileName)).
+		Exec(ctx)
+	if err != nil {
+		return "", errors.Wrap(err, "TemporaryFile.Delete failed")
+	}
+
+	return filePath, nil
 }
 
-func (f *file) MoveFromTemporaryAndDeleteOldFile(fileName, destinationFolder, oldFilePath string) (string, error) {
-	newFilePath, err := f.MoveFromTemporary(fileName, destinationFolder)
+func (f *file) MoveFromTemporaryAndDeleteOldFile(ctx context.Context, client *ent.Client, fileName, destinationFolder, oldFilePath string) (string, error) {
+	newFilePath, err := f.MoveFromTemporary(ctx, client, fileName, destinationFolder)
 	if err != nil {
 		return "", err
 	}
-	// Attempt to delete the old file, but don't fail the operation if it fails.
-	if err = f.Delete(oldFilePath); err != nil {
-		// It's good practice to log this error.
-		// log.Printf("WARN: could not delete old file %s: %v", oldFilePath, err)
+	// The new file is in place, a leftover old file is removed by the janitor
+	if err = f.Delete(ctx, client, oldFilePath); err != nil {
+		f.logger.Warn("Delete old file failed", zap.String("filePath", oldFilePath), zap.Error(err))
 	}
 	return newFilePath, nil
 }
 
-func (f *file) CleanTemporaryFiles(hours int) error {
-	folderPath := filepath.Join(clientRootPath, temporaryFolderName)
-
-	files, err := os.ReadDir(folderPath)
+// Copy references a stored file (e.g. "message_media/x.jpg") from
+// destinationFolder, so the copy outlives deletion of the original. Content is
+// only duplicated across folders.
+func (f *file) Copy(ctx context.Context, client *ent.Client, filePath, destinationFolder string) (string, error) {
+	sourceFileInfo, err := f.Stat(filePath)
 	if err != nil {
-		if os.IsNotExist(err) {
-			return nil
+		return "", err
+	}
+
+	if err := f.validateFileProperties(sourceFileInfo.Size, filePath, destinationFolder); err != nil {
+		return "", err
+	}
+
+	if path.Dir(filePath) == destinationFolder {
+		ok, err := f.acquireBlob(ctx, client, filePath)
+		if err != nil {
+			return "", err
+		}
+		if ok {
+			return filePath, nil
 		}
-		return errors.Errorf("could not read temporary directory: %w", err)
+	}
+
+	return f.storeBlob(ctx, client, filePath, destinationFolder)
+}
+
+// CleanTemporaryFiles deletes temporary files older than hours, except those
+// keep reports as still in use, and returns their paths. Nothing is deleted
+// on a dry run.
+func (f *file) CleanTemporaryFiles(hours int, keep func(fileName string) bool, dryRun bool) ([]string, error) {
+	files, err := f.storage.List(temporaryFolderName)
+	if err != nil {
+		return nil, errors.Errorf("could not read temporary directory: %w", err)
 	}
 
 	expirationTime := time.Now().Add(-time.Duration(hours) * time.Hour)
 
+	var res []string
 	for _, file := range files {
-		if file.IsDir() {
+		if !file.ModTime.Before(expirationTime) || keep(path.Base(file.Key)) {
 			continue
 		}
-
-		info, err := file.Info()
-		if err != nil {
-			continue
+		if !dryRun {
+			if err := f.storage.Delete(file.Key); err != nil {
+				return res, err
+			}
 		}
+		res = append(res, file.Key)
+	}
 
-		if info.ModTime().Before(expirationTime) {
-			filePath := filepath.Join(folderPath, file.Name())
-			os.Remove(filePath) // Bỏ qua lỗi nếu file đã bị xóa bởi tiến trình khác
-		}
+	return res, nil
+}
+
+// Open returns the content of a stored file, reporting a missing file as a
+// bad request since paths come from clients.
+func (f *file) Open(filePath string) (io.ReadCloser, error) {
+	reader, err := f.storage.Open(filePath)
+	if err != nil {
+		return nil, f.mapError(err, filePath)
 	}
+	return reader, nil
+}
 
-	return nil
+func (f *file) Stat(filePath string) (*FileInfo, error) {
+	info, err := f.storage.Stat(filePath)
+	if err != nil {
+		return nil, f.mapError(err, filePath)
+	}
+	return info, nil
 }
 
-func (f *file) GetFilePath(subPath, fileName string) (string, error) {
-	rootPath := clientRootPath
-	// --- BẢO MẬT: Chống Path Traversal ---
-	// subPath đã được xây dựng từ các tham số đã được làm sạch
-	dest := filepath.Join(rootPath, subPath, fileName)
+// URL returns a direct download url for the file, or an empty string when it
+// has to be served by the API.
+func (f *file) URL(filePath, contentDisposition string) (string, error) {
+	url, err := f.storage.URL(filePath, contentDisposition)
+	if err != nil {
+		return "", f.mapError(err, filePath)
+	}
+	return url, nil
+}
 
-	// Kiểm tra lần cuối để chắc chắn không thoát ra khỏi thư mục gốc
-	cleanedDest := filepath.Clean(dest)
-	if !strings.HasPrefix(cleanedDest, filepath.Clean(rootPath)) {
-		return "", apperror.Forbidden("Access denied", nil, nil)
+func (f *file) mapError(err error, filePath string) error {
+	switch {
+	case errors.Is(err, ErrNotExist):
+		return apperror.BadRequest(errors.Errorf("%w: %s", ErrSourceNotExist, filePath).Error(), nil, nil)
+	case errors.Is(err, ErrInvalidKey):
+		// --- BẢO MẬT: Chống Path Traversal ---
+		return apperror.Forbidden("Access denied", nil, nil)
+	default:
+		return err
 	}
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	reader io.Reader
+	count  int64
+}
 
-	return cleanedDest, nil
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.count += int64(n)
+	return n, err
 }
@@ -0,0 +1,153 @@
+package file
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+const (
+	// Larger images are not decoded to keep memory bounded, they are served
+	// at full size only.
+	maxImagePixels    = 50_000_000
+	imageJpegQuality  = 85
+	variantNameFormat = "%s_w%d%s"
+)
+
+var variantSourceExtensions = []string{".jpg", ".jpeg", ".png", ".gif", ".webp"}
+
+// variantPath is where the variant of filePath with the given width is stored,
+// e.g. "message_media/x_w320.jpg". Variants with transparency are kept as PNG.
+func variantPath(filePath string, width int) string {
+	ext := strings.ToLower(path.Ext(filePath))
+	variantExt := ".jpg"
+	if ext == ".png" || ext == ".gif" {
+		variantExt = ".png"
+	}
+	return fmt.Sprintf(variantNameFormat, strings.TrimSuffix(filePath, path.Ext(filePath)), width, variantExt)
+}
+
+func variantWidths(filePath string) []int {
+	if !slices.Contains(variantSourceExtensions, strings.ToLower(path.Ext(filePath))) {
+		return nil
+	}
+	return folderConfigurations[strings.SplitN(filePath, "/", 2)[0]].variantWidths
+}
+
+// imageSize decodes the dimensions of a stored image variants are generated
+// for. ok is false for any other file.
+func (f *file) imageSize(filePath string) (width, height int, ok bool, err error) {
+	if len(variantWidths(filePath)) == 0 {
+		return 0, 0, false, nil
+	}
+
+	reader, err := f.Open(filePath)
+	if err != nil {
+		return 0, 0, false, err
+	}
+	defer reader.Close()
+
+	config, _, err := image.DecodeConfig(reader)
+	if err != nil || config.Width*config.Height > maxImagePixels {
+		// Not decodable, served as is
+		return 0, 0, false, nil
+	}
+	return config.Width, config.Height, true, nil
+}
+
+// imageVariants returns the configured variant widths smaller than width.
+func imageVariants(filePath string, width int) []int {
+	var res []int
+	for _, w := range variantWidths(filePath) {
+		if w < width {
+			res = append(res, w)
+		}
+	}
+	return res
+}
+
+// Variant returns the stored variant of filePath best suited for width: the
+// smallest one at least as wide, or the original when there is none.
+func (f *file) Variant(filePath string, width int) string {
+	for _, w := range variantWidths(filePath) {
+		if w < width {
+			continue
+		}
+		variant := variantPath(filePath, w)
+		if _, err := f.storage.Stat(variant); err != nil {
+			break
+		}
+		return variant
+	}
+	return filePath
+}
+
+// generateVariants stores downscaled copies of the image at filePath for every
+// configured width smaller than the image. Animated GIFs only keep their first
+// frame in variants.
+func (f *file) generateVariants(filePath string) error {
+	width, height, ok, err := f.imageSize(filePath)
+	if err != nil || !ok {
+		return err
+	}
+	variants := imageVariants(filePath, width)
+	if len(variants) == 0 {
+		return nil
+	}
+
+	reader, err := f.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	src, _, err := image.Decode(reader)
+	if err != nil {
+		// Corrupt after a valid header, served as is
+		return nil
+	}
+
+	for _, variantWidth := range variants {
+		variantHeight := max(height*variantWidth/width, 1)
+		dst := image.NewRGBA(image.Rect(0, 0, variantWidth, variantHeight))
+		draw.CatmullRom.Scale(dst, dst.Bounds(), src, src.Bounds(), draw.Src, nil)
+
+		key := variantPath(filePath, variantWidth)
+		var buf bytes.Buffer
+		if path.Ext(key) == ".png" {
+			err = png.Encode(&buf, dst)
+		} else {
+			err = jpeg.Encode(&buf, dst, &jpeg.Options{Quality: imageJpegQuality})
+		}
+		if err != nil {
+			return errors.Errorf("could not encode image variant: %w", err)
+		}
+
+		if err := f.storage.Save(key, &buf, int64(buf.Len())); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// deleteVariants removes every variant that may have been generated for
+// filePath.
+func (f *file) deleteVariants(filePath string) error {
+	for _, width := range variantWidths(filePath) {
+		if err := f.storage.Delete(variantPath(filePath, width)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
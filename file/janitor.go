@@ -0,0 +1,447 @@
+package file
+
+import (
+	"backend/config"
+	"backend/database"
+	"backend/database/ent"
+	"backend/database/ent/blob"
+	"backend/database/ent/draft"
+	"backend/database/ent/messagemedia"
+	"backend/database/ent/scheduledmessage"
+	"backend/database/ent/schema/enum"
+	"backend/database/ent/temporaryfile"
+	"backend/database/ent/user"
+	"context"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"entgo.io/ent/dialect/sql"
+	"github.com/cockroachdb/errors"
+	"go.uber.org/fx"
+)
+
+// referencedFolders hold files referenced from the database: message media,
+// avatars and covers, and media of pending scheduled messages.
+var referencedFolders = []string{FolderMessageMedia, FolderUser, FolderScheduledMedia}
+
+// variantPattern matches the name of an image variant, capturing the name of
+// its source without extension.
+var variantPattern = regexp.MustCompile(`^(.+)_w\d+\.(jpg|png)$`)
+
+// Janitor keeps storage and the database in step: it deletes expired or
+// already stored temporary uploads and stored files nothing references, and
+// reports references to files missing from storage. Avatars and covers
+// pointing at a missing file are cleared.
+type Janitor struct {
+	client  *ent.Client
+	file    File
+	storage Storage
+}
+
+type janitorParams struct {
+	fx.In
+	Client  *ent.Client
+	File    File
+	Storage Storage
+}
+
+func newJanitor(p janitorParams) *Janitor {
+	return &Janitor{
+		client:  p.Client,
+		file:    p.File,
+		storage: p.Storage,
+	}
+}
+
+// Run cleans up storage once. On a dry run nothing is deleted or repaired,
+// the report lists what would be.
+func (j *Janitor) Run(ctx context.Context, dryRun bool) (*JanitorReport, error) {
+	report := &JanitorReport{DryRun: dryRun}
+
+	// Drafts keep their media in the temporary folder until sent, or until
+	// left untouched for too long
+	draftMedia, err := j.draftMedia(ctx, dryRun)
+	if err != nil {
+		return nil, err
+	}
+	// Uploads are tracked until stored, their temporary copy only goes once
+	// the transaction storing them had time to commit
+	tracked, err := j.trackedTemporaryFiles(ctx)
+	if err != nil {
+		return nil, err
+	}
+	report.TemporaryFiles, err = j.file.CleanTemporaryFiles(config.OrphanedFileGraceInHour, func(fileName string) bool {
+		return draftMedia[fileName] || tracked[fileName]
+	}, dryRun)
+	if err != nil {
+		return nil, err
+	}
+	if !dryRun {
+		if err := j.forgetTemporaryFiles(ctx, report.TemporaryFiles); err != nil {
+			return nil, err
+		}
+	}
+
+	scheduledMedia, err := j.scheduledMedia(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Files are only orphaned once the transaction storing their reference
+	// had time to commit
+	before := time.Now().Add(-config.OrphanedFileGraceInHour * time.Hour)
+
+	stored := map[string]bool{}
+	for _, folder := range referencedFolders {
+		files, err := j.storage.List(folder)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range files {
+			stored[f.Key] = true
+		}
+
+		orphaned, err := j.orphanedFiles(ctx, files, scheduledMedia, before)
+		if err != nil {
+			return nil, err
+		}
+		for _, filePath := range orphaned {
+			if !dryRun {
+				deleted, err := j.deleteOrphan(ctx, filePath, before)
+				if err != nil {
+					return nil, err
+				}
+				if !deleted {
+					continue
+				}
+			}
+			report.OrphanedFiles = append(report.OrphanedFiles, filePath)
+		}
+	}
+
+	report.MissingFiles, err = j.missingFiles(ctx, stored, scheduledMedia, dryRun)
+	if err != nil {
+		return nil, err
+	}
+
+	return report, nil
+}
+
+// orphanedFiles returns the files older than before nothing references.
+// Variants are only returned when their source is gone, otherwise they go
+// with it.
+func (j *Janitor) orphanedFiles(ctx context.Context, files []*FileInfo, scheduledMedia map[string]bool, before time.Time) ([]string, error) {
+	sources := map[string]bool{}
+	var keys []string
+	for _, f := range files {
+		if !variantPattern.MatchString(path.Base(f.Key)) {
+			sources[strings.TrimSuffix(f.Key, path.Ext(f.Key))] = true
+			keys = append(keys, f.Key)
+		}
+	}
+
+	referenced, err := j.referenced(ctx, keys, scheduledMedia)
+	if err != nil {
+		return nil, err
+	}
+
+	var res []string
+	for _, f := range files {
+		if !f.ModTime.Before(before) {
+			continue
+		}
+		if match := variantPattern.FindStringSubmatch(path.Base(f.Key)); match != nil {
+			if !sources[path.Join(path.Dir(f.Key), match[1])] {
+				res = append(res, f.Key)
+			}
+			continue
+		}
+		if !referenced[f.Key] {
+			res = append(res, f.Key)
+		}
+	}
+
+	return res, nil
+}
+
+// referenced returns which of the given paths are referenced from the
+// database.
+func (j *Janitor) referenced(ctx context.Context, keys []string, scheduledMedia map[string]bool) (map[string]bool, error) {
+	res := map[string]bool{}
+	for _, key := range keys {
+		if scheduledMedia[key] {
+			res[key] = true
+		}
+	}
+
+	for start := 0; start < len(keys); start += config.JanitorBatchSize {
+		batch := keys[start:min(start+config.JanitorBatchSize, len(keys))]
+
+		srcs, err := j.client.MessageMedia.
+			Query().
+			Where(messagemedia.SrcIn(batch...)).
+			Select(messagemedia.FieldSrc).
+			Strings(ctx)
+		if err != nil {
+			return nil, errors.Wrap(err, "Query failed")
+		}
+		for _, src := range srcs {
+			res[src] = true
+		}
+
+		users, err := j.client.User.
+			Query().
+			Where(user.Or(user.AvatarIn(batch...), user.CoverIn(batch...))).
+			Select(user.FieldAvatar, user.FieldCover).
+			All(ctx)
+		if err != nil {
+			return nil, errors.Wrap(err, "Query failed")
+		}
+		for _, u := range users {
+			res[u.Avatar] = true
+			res[u.Cover] = true
+		}
+	}
+
+	return res, nil
+}
+
+// deleteOrphan deletes an orphaned file with its variants. A shared file
+// referenced again since before is kept, reporting false.
+func (j *Janitor) deleteOrphan(ctx context.Context, filePath string, before time.Time) (bool, error) {
+	deleted := false
+	err := database.WithTx(ctx, j.client, func(tx *ent.Tx) error {
+		queryBuilder := tx.Blob.Query().Where(blob.Key(filePath))
+		queryBuilder.Modify(func(s *sql.Selector) {
+			s.ForUpdate()
+		})
+		b, err := queryBuilder.First(ctx)
+		if err != nil && !ent.IsNotFound(err) {
+			return errors.Wrap(err, "Query failed")
+		}
+		if b != nil {
+			if b.UpdatedAt.After(before) {
+				return nil
+			}
+			if err := tx.Blob.DeleteOne(b).Exec(ctx); err != nil {
+				return errors.Wrap(err, "Blob.Delete failed")
+			}
+		}
+
+		for _, width := range variantWidths(filePath) {
+			if err := j.storage.Delete(variantPath(filePath, width)); err != nil {
+				return err
+			}
+		}
+		if err := j.storage.Delete(filePath); err != nil {
+			return err
+		}
+		if err := forgetHash(ctx, tx.Client(), filePath); err != nil {
+			return err
+		}
+		deleted = true
+		return nil
+	})
+	return deleted, err
+}
+
+// missingFiles returns the paths referenced from the database that are not
+// stored, clearing avatars and covers pointing at them unless on a dry run.
+func (j *Janitor) missingFiles(ctx context.Context, stored, scheduledMedia map[string]bool, dryRun bool) ([]string, error) {
+	var res []string
+	isMissing := func(filePath string) bool {
+		if filePath == "" || stored[filePath] {
+			return false
+		}
+		for _, folder := range referencedFolders {
+			if strings.HasPrefix(filePath, folder+"/") {
+				// Stored since the folder was listed otherwise
+				_, err := j.storage.Stat(filePath)
+				return errors.Is(err, ErrNotExist)
+			}
+		}
+		return false
+	}
+
+	for lastId := 0; ; {
+		media, err := j.client.MessageMedia.
+			Query().
+			Where(messagemedia.IDGT(lastId)).
+			Order(ent.Asc(messagemedia.FieldID)).
+			Limit(config.JanitorBatchSize).
+			Select(messagemedia.FieldSrc).
+			All(ctx)
+		if err != nil {
+			return nil, errors.Wrap(err, "Query failed")
+		}
+		for _, m := range media {
+			if isMissing(m.Src) {
+				res = append(res, m.Src)
+			}
+			lastId = m.ID
+		}
+		if len(media) < config.JanitorBatchSize {
+			break
+		}
+	}
+
+	for lastId := 0; ; {
+		users, err := j.client.User.
+			Query().
+			Where(
+				user.IDGT(lastId),
+				user.Or(user.AvatarNEQ(""), user.CoverNEQ("")),
+			).
+			Order(ent.Asc(user.FieldID)).
+			Limit(config.JanitorBatchSize).
+			Select(user.FieldAvatar, user.FieldCover).
+			All(ctx)
+		if err != nil {
+			return nil, errors.Wrap(err, "Query failed")
+		}
+		for _, u := range users {
+			if isMissing(u.Avatar) {
+				res = append(res, u.Avatar)
+				if !dryRun {
+					// Guarded so an avatar changed in the meantime is kept
+					err := j.client.User.Update().
+						Where(user.ID(u.ID), user.Avatar(u.Avatar)).
+						ClearAvatar().
+						Exec(ctx)
+					if err != nil {
+						return nil, errors.Wrap(err, "User.Update failed")
+					}
+				}
+			}
+			if isMissing(u.Cover) {
+				res = append(res, u.Cover)
+				if !dryRun {
+					err := j.client.User.Update().
+						Where(user.ID(u.ID), user.Cover(u.Cover)).
+						ClearCover().
+						Exec(ctx)
+					if err != nil {
+						return nil, errors.Wrap(err, "User.Update failed")
+					}
+				}
+			}
+			lastId = u.ID
+		}
+		if len(users) < config.JanitorBatchSize {
+			break
+		}
+	}
+
+	for filePath := range scheduledMedia {
+		if isMissing(filePath) {
+			res = append(res, filePath)
+		}
+	}
+
+	return res, nil
+}
+
+// forgetTemporaryFiles deletes the records of deleted temporary files, which
+// no longer count towards the quota of their uploader.
+func (j *Janitor) forgetTemporaryFiles(ctx context.Context, filePaths []string) error {
+	for start := 0; start < len(filePaths); start += config.JanitorBatchSize {
+		batch := filePaths[start:min(start+config.JanitorBatchSize, len(filePaths))]
+
+		names := make([]string, len(batch))
+		for i, filePath := range batch {
+			names[i] = path.Base(filePath)
+		}
+		_, err := j.client.TemporaryFile.Delete().Where(temporaryfile.NameIn(names...)).Exec(ctx)
+		if err != nil {
+			return errors.Wrap(err, "TemporaryFile.Delete failed")
+		}
+	}
+	return nil
+}
+
+// trackedTemporaryFiles returns the names of the uploads not expired yet
+// that were not stored since.
+func (j *Janitor) trackedTemporaryFiles(ctx context.Context) (map[string]bool, error) {
+	names, err := j.client.TemporaryFile.
+		Query().
+		Where(temporaryfile.CreatedAtGT(time.Now().Add(-config.TemporaryFileExpiresInHour * time.Hour))).
+		Select(temporaryfile.FieldName).
+		Strings(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "Query failed")
+	}
+
+	res := make(map[string]bool, len(names))
+	for _, name := range names {
+		res[name] = true
+	}
+	return res, nil
+}
+
+func (j *Janitor) draftMedia(ctx context.Context, dryRun bool) (map[string]bool, error) {
+	expired := draft.UpdatedAtLT(time.Now().Add(-config.DraftMediaExpiresInDay * 24 * time.Hour))
+	if !dryRun {
+		_, err := j.client.Draft.
+			Update().
+			Where(draft.MediaNotNil(), expired).
+			ClearMedia().
+			Save(ctx)
+		if err != nil {
+			return nil, errors.Wrap(err, "Draft.Update failed")
+		}
+	}
+
+	drafts, err := j.client.Draft.
+		Query().
+		Where(
+			draft.MediaNotNil(),
+			draft.Not(expired),
+		).
+		Select(draft.FieldMedia).
+		All(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "Query failed")
+	}
+
+	res := map[string]bool{}
+	for _, d := range drafts {
+		for _, name := range d.Media {
+			res[name] = true
+		}
+	}
+	return res, nil
+}
+
+func (j *Janitor) scheduledMedia(ctx context.Context) (map[string]bool, error) {
+	scheduled, err := j.client.ScheduledMessage.
+		Query().
+		Where(
+			scheduledmessage.MediaNotNil(),
+			// Media of sent messages was deleted once copied to the message
+			scheduledmessage.StatusNEQ(enum.ScheduledMessageStatusSent),
+		).
+		Select(scheduledmessage.FieldMedia).
+		All(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "Query failed")
+	}
+
+	res := map[string]bool{}
+	for _, m := range scheduled {
+		for _, media := range m.Media {
+			res[media.Src] = true
+		}
+	}
+	return res, nil
+}
+
+// JanitorReport lists the files a janitor run deleted, or would delete on a
+// dry run, and the referenced files missing from storage.
+type JanitorReport struct {
+	DryRun         bool     `json:"dryRun"`
+	TemporaryFiles []string `json:"temporaryFiles"`
+	OrphanedFiles  []string `json:"orphanedFiles"`
+	MissingFiles   []string `json:"missingFiles"`
+}
@@ -0,0 +1,86 @@
+package file
+
+import (
+	"backend/config"
+	"backend/logger"
+	"context"
+	"time"
+
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+)
+
+// JanitorWorker runs the janitor periodically while the server is up.
+type JanitorWorker struct {
+	janitor *Janitor
+	logger  *logger.Logger
+}
+
+type janitorWorkerParams struct {
+	fx.In
+	fx.Lifecycle
+	Janitor *Janitor
+	Logger  *logger.Logger
+}
+
+func newJanitorWorker(p janitorWorkerParams) *JanitorWorker {
+	w := &JanitorWorker{
+		janitor: p.Janitor,
+		logger:  p.Logger,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	p.Lifecycle.Append(fx.Hook{
+		OnStart: func(context.Context) error {
+			go func() {
+				defer close(done)
+				w.run(ctx)
+			}()
+			return nil
+		},
+		OnStop: func(stopCtx context.Context) error {
+			cancel()
+			select {
+			case <-done:
+			case <-stopCtx.Done():
+			}
+			return nil
+		},
+	})
+
+	return w
+}
+
+func (w *JanitorWorker) run(ctx context.Context) {
+	ticker := time.NewTicker(config.JanitorIntervalInMinute * time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			w.clean(ctx)
+		}
+	}
+}
+
+func (w *JanitorWorker) clean(ctx context.Context) {
+	report, err := w.janitor.Run(ctx, false)
+	if err != nil {
+		w.logger.Error("Clean storage failed", zap.Error(err))
+		return
+	}
+
+	if len(report.TemporaryFiles) > 0 || len(report.OrphanedFiles) > 0 {
+		w.logger.Info("Cleaned storage",
+			zap.Int("temporaryFiles", len(report.TemporaryFiles)),
+			zap.Strings("orphanedFiles", report.OrphanedFiles),
+		)
+	}
+	if len(report.MissingFiles) > 0 {
+		w.logger.Warn("Referenced files missing from storage", zap.Strings("missingFiles", report.MissingFiles))
+	}
+}
@@ -0,0 +1,165 @@
+package file
+
+import (
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/cockroachdb/errors"
+)
+
+// localStorage keeps files on the local disk under root.
+type localStorage struct {
+	root string
+}
+
+func newLocalStorage(root string) *localStorage {
+	return &localStorage{root: root}
+}
+
+func (s *localStorage) resolve(key string) (string, error) {
+	key, err := cleanKey(key)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(s.root, filepath.FromSlash(key)), nil
+}
+
+func (s *localStorage) Save(key string, reader io.Reader, size int64) error {
+	filePath, err := s.resolve(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(filePath), os.ModePerm); err != nil {
+		return errors.Errorf("could not create directory: %w", err)
+	}
+
+	dst, err := os.Create(filePath)
+	if err != nil {
+		return errors.Errorf("could not create destination file: %w", err)
+	}
+	defer dst.Close()
+
+	if _, err := io.Copy(dst, reader); err != nil {
+		dst.Close()
+		os.Remove(filePath)
+		return errors.Errorf("could not save file content: %w", err)
+	}
+
+	return nil
+}
+
+func (s *localStorage) Open(key string) (io.ReadCloser, error) {
+	filePath, err := s.resolve(key)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(filePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, errors.Wrap(ErrNotExist, key)
+		}
+		return nil, errors.Errorf("could not open file: %w", err)
+	}
+
+	return f, nil
+}
+
+func (s *localStorage) Stat(key string) (*FileInfo, error) {
+	filePath, err := s.resolve(key)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(filePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, errors.Wrap(ErrNotExist, key)
+		}
+		return nil, errors.Errorf("could not get file info: %w", err)
+	}
+
+	return &FileInfo{
+		Key:     key,
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+	}, nil
+}
+
+func (s *localStorage) Move(sourceKey, destinationKey string) error {
+	sourcePath, err := s.resolve(sourceKey)
+	if err != nil {
+		return err
+	}
+	destinationPath, err := s.resolve(destinationKey)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(destinationPath), os.ModePerm); err != nil {
+		return errors.Errorf("could not create destination directory: %w", err)
+	}
+
+	if err := os.Rename(sourcePath, destinationPath); err != nil {
+		if os.IsNotExist(err) {
+			return errors.Wrap(ErrNotExist, sourceKey)
+		}
+		return errors.Errorf("could not move file: %w", err)
+	}
+
+	return nil
+}
+
+func (s *localStorage) Delete(key string) error {
+	filePath, err := s.resolve(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(filePath); err != nil && !os.IsNotExist(err) {
+		return errors.Errorf("could not delete file: %w", err)
+	}
+
+	return nil
+}
+
+func (s *localStorage) List(prefix string) ([]*FileInfo, error) {
+	folderPath, err := s.resolve(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(folderPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, errors.Errorf("could not read directory: %w", err)
+	}
+
+	var res []*FileInfo
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			// Removed by another process in the meantime
+			continue
+		}
+		res = append(res, &FileInfo{
+			Key:     path.Join(prefix, entry.Name()),
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+		})
+	}
+
+	return res, nil
+}
+
+func (s *localStorage) URL(key, contentDisposition string) (string, error) {
+	return "", nil
+}
@@ -0,0 +1,75 @@
+package file
+
+import (
+	"bytes"
+	"image"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+	"github.com/gabriel-vasile/mimetype"
+)
+
+// Metadata describes a stored file, so clients do not have to guess from its
+// path. Duration is in seconds.
+type Metadata struct {
+	MimeType string   `json:"mimeType"`
+	Size     int64    `json:"size"`
+	Width    *int     `json:"width,omitempty"`
+	Height   *int     `json:"height,omitempty"`
+	Duration *float64 `json:"duration,omitempty"`
+	Variants []int    `json:"variants,omitempty"`
+}
+
+// isoMediaMimeTypes are the formats mp4Duration can read.
+var isoMediaMimeTypes = []string{"video/mp4", "video/quicktime", "video/x-m4v", "video/3gpp", "video/3gpp2"}
+
+// Inspect reads the metadata of a stored file from its content.
+func (f *file) Inspect(filePath string) (*Metadata, error) {
+	info, err := f.Stat(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	reader, err := f.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	head := make([]byte, sniffLimit)
+	n, err := io.ReadFull(reader, head)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, errors.Errorf("could not read file content: %w", err)
+	}
+	head = head[:n]
+
+	res := &Metadata{
+		MimeType: mimetype.Detect(head).String(),
+		Size:     info.Size,
+	}
+
+	switch {
+	case strings.HasPrefix(res.MimeType, "image/"):
+		config, _, err := image.DecodeConfig(io.MultiReader(bytes.NewReader(head), reader))
+		if err != nil {
+			break
+		}
+		res.Width = &config.Width
+		res.Height = &config.Height
+		if config.Width*config.Height <= maxImagePixels {
+			res.Variants = imageVariants(filePath, config.Width)
+		}
+	case slices.Contains(isoMediaMimeTypes, res.MimeType):
+		rs, ok := reader.(io.ReadSeeker)
+		if !ok {
+			break
+		}
+		if duration, ok := mp4Duration(rs); ok {
+			res.Duration = &duration
+		}
+	}
+
+	return res, nil
+}
@@ -0,0 +1,83 @@
+package file
+
+import (
+	"backend/apperror"
+	"bytes"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+	"github.com/gabriel-vasile/mimetype"
+)
+
+// sniffLimit is how much of a file is read to detect its content type.
+const sniffLimit = 3072
+
+var ErrContentMismatch = errors.New("File content does not match its extension")
+
+// extensionMimeTypes lists the content types a file with a given extension
+// may have. Detected types match through their parents too, e.g. a 3GP video
+// is also accepted as MP4.
+var extensionMimeTypes = map[string][]string{
+	".jpg":  {"image/jpeg"},
+	".jpeg": {"image/jpeg"},
+	".png":  {"image/png"},
+	".gif":  {"image/gif"},
+	".bmp":  {"image/bmp"},
+	".tif":  {"image/tiff"},
+	".tiff": {"image/tiff"},
+	".webp": {"image/webp"},
+	".heic": {"image/heic", "image/heic-sequence", "image/heif", "image/heif-sequence"},
+	".heif": {"image/heif", "image/heif-sequence", "image/heic", "image/heic-sequence"},
+	".raw":  {"image/tiff"},
+	".mp4":  {"video/mp4", "video/quicktime"},
+	".m4v":  {"video/x-m4v", "video/mp4"},
+	".mov":  {"video/quicktime", "video/mp4"},
+	".3gp":  {"video/3gpp", "video/mp4"},
+	".avi":  {"video/x-msvideo"},
+	".mkv":  {"video/x-matroska"},
+	".webm": {"video/webm"},
+	".wmv":  {"video/x-ms-asf"},
+	".flv":  {"video/x-flv"},
+	".mpeg": {"video/mpeg"},
+	".mpg":  {"video/mpeg"},
+	".ogv":  {"video/ogg"},
+}
+
+// sniff reads the head of reader and checks the content is of a type
+// filename's extension allows. The returned reader yields the whole content
+// again.
+func sniff(reader io.Reader, filename string) (string, io.Reader, error) {
+	head := make([]byte, sniffLimit)
+	n, err := io.ReadFull(reader, head)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return "", nil, errors.Errorf("could not read file content: %w", err)
+	}
+	head = head[:n]
+
+	mimeType, err := detectMimeType(head, filename)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return mimeType, io.MultiReader(bytes.NewReader(head), reader), nil
+}
+
+func detectMimeType(head []byte, filename string) (string, error) {
+	ext := strings.ToLower(filepath.Ext(filename))
+	detected := mimetype.Detect(head)
+
+	for m := detected; m != nil; m = m.Parent() {
+		for _, allowed := range extensionMimeTypes[ext] {
+			if m.Is(allowed) {
+				return detected.String(), nil
+			}
+		}
+	}
+
+	return "", apperror.BadRequest(
+		errors.Errorf("%w: '%s' is %s", ErrContentMismatch, ext, detected.String()).Error(),
+		nil, nil,
+	)
+}
@@ -3,5 +3,17 @@ package file
 import "go.uber.org/fx"
 
 var Module = fx.Module("file",
-	fx.Provide(newRouter, newFile),
+	fx.Provide(newRouter, newFile, newStorage, newUpload, newUploadReaper, newJanitor, newJanitorWorker, newQuota),
+	fx.Invoke(func(*UploadReaper) {}, func(*JanitorWorker) {}),
+)
+
+// JanitorModule provides the janitor alone, to run it outside of the server.
+var JanitorModule = fx.Module("file",
+	fx.Provide(newFile, newStorage, newJanitor),
+)
+
+// QuotaModule provides storage quotas alone, to manage them outside of the
+// server.
+var QuotaModule = fx.Module("file",
+	fx.Provide(newQuota),
 )
@@ -0,0 +1,263 @@
+package file
+
+import (
+	"backend/apperror"
+	"backend/config"
+	"backend/database/ent"
+	"backend/database/ent/blob"
+	"backend/database/ent/message"
+	"backend/database/ent/messagemedia"
+	"backend/database/ent/scheduledmessage"
+	"backend/database/ent/schema/enum"
+	"backend/database/ent/temporaryfile"
+	"backend/database/ent/upload"
+	"backend/database/ent/user"
+	"context"
+	"database/sql"
+	"path"
+	"strings"
+	"time"
+
+	entsql "entgo.io/ent/dialect/sql"
+	"github.com/cockroachdb/errors"
+	"go.uber.org/fx"
+)
+
+// Quota limits the bytes a user stores: files uploaded to the temporary
+// folder, resumable uploads in progress, message media they sent, their
+// avatar and cover and media of their scheduled messages. Shared files count
+// for every user referencing them.
+type Quota struct {
+	defaultQuota int64
+}
+
+type quotaParams struct {
+	fx.In
+	Env *config.Env
+}
+
+func newQuota(p quotaParams) *Quota {
+	return &Quota{
+		defaultQuota: p.Env.StorageQuotaInMb * 1024 * 1024,
+	}
+}
+
+func (s *Quota) GetUsage(ctx context.Context, client *ent.Client, userId int) (*StorageUsage, error) {
+	u, err := client.User.Query().Where(user.ID(userId)).First(ctx)
+	if err != nil && !ent.IsNotFound(err) {
+		return nil, errors.Wrap(err, "User.Query() failed")
+	}
+	if u == nil {
+		return nil, apperror.NotFound("User not found", nil, nil)
+	}
+
+	res := &StorageUsage{
+		Quota:   s.defaultQuota,
+		Folders: map[string]int64{},
+		Types:   map[string]int64{},
+	}
+	if u.StorageQuota != nil {
+		res.Quota = *u.StorageQuota
+	}
+
+	type sizeRow struct {
+		MimeType sql.NullString `json:"mime_type"`
+		Sum      sql.NullInt64  `json:"sum"`
+	}
+
+	var temporaryRows []sizeRow
+	err = client.TemporaryFile.
+		Query().
+		Where(temporaryfile.UserId(userId)).
+		GroupBy(temporaryfile.FieldMimeType).
+		Aggregate(ent.Sum(temporaryfile.FieldSize)).
+		Scan(ctx, &temporaryRows)
+	if err != nil {
+		return nil, errors.Wrap(err, "Temporary file size query failed")
+	}
+	for _, row := range temporaryRows {
+		res.add(temporaryFolderName, row.MimeType.String, row.Sum.Int64)
+	}
+
+	// Uploads in progress reserve their full size
+	uploads, err := client.Upload.
+		Query().
+		Where(
+			upload.UserId(userId),
+			upload.ExpiresAtGT(time.Now()),
+		).
+		All(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "Query failed")
+	}
+	for _, up := range uploads {
+		res.add(uploadFolderName, extensionMimeType(up.Name), up.Size)
+	}
+
+	var mediaRows []sizeRow
+	err = client.MessageMedia.
+		Query().
+		Where(messagemedia.HasMessageWith(message.UserId(userId))).
+		GroupBy(messagemedia.FieldMimeType).
+		Aggregate(ent.Sum(messagemedia.FieldSize)).
+		Scan(ctx, &mediaRows)
+	if err != nil {
+		return nil, errors.Wrap(err, "Message media size query failed")
+	}
+	for _, row := range mediaRows {
+		res.add(FolderMessageMedia, row.MimeType.String, row.Sum.Int64)
+	}
+
+	// Avatars, covers and scheduled media are only stored as blobs, which
+	// know their size
+	var keys []string
+	for _, key := range []string{u.Avatar, u.Cover} {
+		if key != "" {
+			keys = append(keys, key)
+		}
+	}
+	scheduled, err := client.ScheduledMessage.
+		Query().
+		Where(
+			scheduledmessage.UserId(userId),
+			scheduledmessage.MediaNotNil(),
+			scheduledmessage.StatusNEQ(enum.ScheduledMessageStatusSent),
+		).
+		Select(scheduledmessage.FieldMedia).
+		All(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "Query failed")
+	}
+	for _, m := range scheduled {
+		for _, media := range m.Media {
+			keys = append(keys, media.Src)
+		}
+	}
+
+	if len(keys) > 0 {
+		blobs, err := client.Blob.Query().Where(blob.KeyIn(keys...)).All(ctx)
+		if err != nil {
+			return nil, errors.Wrap(err, "Query failed")
+		}
+		sizes := make(map[string]int64, len(blobs))
+		for _, b := range blobs {
+			sizes[b.Key] = b.Size
+		}
+		for _, key := range keys {
+			res.add(strings.SplitN(key, "/", 2)[0], extensionMimeType(key), sizes[key])
+		}
+	}
+
+	return res, nil
+}
+
+// Check fails with a quota exceeded error when storing size more bytes would
+// take the user over their quota. client must be a transaction that also
+// records the bytes: the user stays locked until it ends, so concurrent checks
+// of the same user wait and then count them.
+func (s *Quota) Check(ctx context.Context, client *ent.Client, p *CheckQuotaParams) error {
+	queryBuilder := client.User.Query().Where(user.ID(p.UserId))
+	queryBuilder.Modify(func(s *entsql.Selector) {
+		s.ForUpdate()
+	})
+	if _, err := queryBuilder.First(ctx); err != nil && !ent.IsNotFound(err) {
+		return errors.Wrap(err, "User.Query() failed")
+	}
+
+	usage, err := s.GetUsage(ctx, client, p.UserId)
+	if err != nil {
+		return err
+	}
+
+	if usage.Used+p.Size > usage.Quota {
+		return apperror.QuotaExceeded(messageQuotaExceeded, map[string]int64{
+			"quota": usage.Quota,
+			"used":  usage.Used,
+		}, nil)
+	}
+
+	return nil
+}
+
+// TrackTemporary records a file uploaded to the temporary folder towards the
+// quota of its uploader.
+func (s *Quota) TrackTemporary(ctx context.Context, client *ent.Client, p *TrackTemporaryParams) error {
+	err := client.TemporaryFile.Create().
+		SetName(p.Name).
+		SetSize(p.Size).
+		SetMimeType(p.MimeType).
+		SetUserID(p.UserId).
+		Exec(ctx)
+	if err != nil {
+		return errors.Wrap(err, "TemporaryFile.Create failed")
+	}
+	return nil
+}
+
+// SetQuota overrides the quota of a user, a nil quota restores the default.
+func (s *Quota) SetQuota(ctx context.Context, client *ent.Client, p *SetQuotaParams) error {
+	updateBuilder := client.User.UpdateOneID(p.UserId)
+	if p.Quota != nil {
+		updateBuilder.SetStorageQuota(*p.Quota)
+	} else {
+		updateBuilder.ClearStorageQuota()
+	}
+
+	if err := updateBuilder.Exec(ctx); err != nil {
+		if ent.IsNotFound(err) {
+			return apperror.NotFound("User not found", nil, nil)
+		}
+		return errors.Wrap(err, "User.Update() failed")
+	}
+	return nil
+}
+
+// extensionMimeType guesses the content type of a file from its extension,
+// for files whose content type is not stored.
+func extensionMimeType(filePath string) string {
+	if mimeTypes := extensionMimeTypes[strings.ToLower(path.Ext(filePath))]; len(mimeTypes) > 0 {
+		return mimeTypes[0]
+	}
+	return ""
+}
+
+const (
+	messageQuotaExceeded = "Storage quota exceeded"
+)
+
+// StorageUsage is the bytes a user stores, in total and broken down by
+// folder and by type of content (image, video or other).
+type StorageUsage struct {
+	Quota   int64            `json:"quota"`
+	Used    int64            `json:"used"`
+	Folders map[string]int64 `json:"folders"`
+	Types   map[string]int64 `json:"types"`
+}
+
+func (u *StorageUsage) add(folder, mimeType string, size int64) {
+	mediaType, _, _ := strings.Cut(mimeType, "/")
+	if mediaType != "image" && mediaType != "video" {
+		mediaType = "other"
+	}
+
+	u.Used += size
+	u.Folders[folder] += size
+	u.Types[mediaType] += size
+}
+
+type CheckQuotaParams struct {
+	UserId int
+	Size   int64
+}
+
+type TrackTemporaryParams struct {
+	UserId   int
+	Name     string
+	Size     int64
+	MimeType string
+}
+
+type SetQuotaParams struct {
+	UserId int
+	Quota  *int64
+}
@@ -3,8 +3,18 @@ package file
 import (
 	"backend/apperror"
 	"backend/common/result"
+	"backend/database"
+	"backend/database/ent"
 	"backend/http/validation"
 	"backend/security/auth"
+	"backend/security/jwt"
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"path"
+	"strconv"
+	"time"
 
 	"github.com/kataras/iris/v12"
 	"github.com/kataras/iris/v12/core/router"
@@ -12,17 +22,26 @@ import (
 )
 
 type Router struct {
-	file File
+	client *ent.Client
+	file   File
+	upload *Upload
+	quota  *Quota
 }
 
 type routerParams struct {
 	fx.In
-	File File
+	Client *ent.Client
+	File   File
+	Upload *Upload
+	Quota  *Quota
 }
 
 func newRouter(p routerParams) *Router {
 	return &Router{
-		file: p.File,
+		client: p.Client,
+		file:   p.File,
+		upload: p.Upload,
+		quota:  p.Quota,
 	}
 }
 
@@ -30,52 +49,315 @@ func (r *Router) Register(routerGroup router.Party) {
 	{
 		router := routerGroup.Party("/file")
 
-		router.Get("/client/{folderName}/{fileName}", validation.Validate[getClientFileParams](validation.ReadParams), func(ctx iris.Context) {
+		router.Get("/client/{folderName}/{fileName}", validation.Validate[getClientFileParams](validation.ReadParams), validation.Validate[getClientFileQuery](validation.ReadQuery), func(ctx iris.Context) {
 			params := ctx.Values().Get(string(validation.ReadParams)).(*getClientFileParams)
-			subPath := params.FolderName
-			cleanedDest, err := r.file.GetFilePath(subPath, params.FileName)
+			query := ctx.Values().Get(string(validation.ReadQuery)).(*getClientFileQuery)
+			filePath := path.Join(params.FolderName, params.FileName)
 
+			// The name of a public file is not signed, so it is not trusted
+			name := ""
+			if !IsPublic(filePath) {
+				if !r.file.Verify(filePath, query.Name, query.Expires, query.Signature) {
+					ctx.SetErr(apperror.Forbidden("Access denied", nil, nil))
+					return
+				}
+				name = query.Name
+			}
+
+			// A resized variant shares the access rules of the original
+			servedPath := filePath
+			if query.Width > 0 {
+				servedPath = r.file.Variant(filePath, query.Width)
+			}
+
+			disposition := contentDisposition(name, servedPath, query.Download)
+
+			// Storages reachable by clients hand out a presigned url instead
+			url, err := r.file.URL(servedPath, disposition)
+			if err != nil {
+				ctx.SetErr(err)
+				return
+			}
+			if url != "" {
+				ctx.Redirect(url, iris.StatusTemporaryRedirect)
+				return
+			}
+
+			info, err := r.file.Stat(servedPath)
 			if err != nil {
 				ctx.SetErr(err)
 				return
 			}
 
-			ctx.ServeFile(cleanedDest)
+			tag, immutable, err := etag(ctx, r.client, r.file, servedPath, info)
+			if err != nil {
+				ctx.SetErr(err)
+				return
+			}
+			ctx.Header("ETag", tag)
+			ctx.Header("Content-Disposition", disposition)
+
+			if IsPublic(filePath) {
+				// Public file names are never reused, so they can be cached for good
+				ctx.Header("Cache-Control", "public, max-age=31536000, immutable")
+			} else {
+				cacheControl := fmt.Sprintf("private, max-age=%d", max(query.Expires-time.Now().Unix(), 0))
+				if immutable {
+					cacheControl += ", immutable"
+				}
+				ctx.Header("Cache-Control", cacheControl)
+			}
+
+			reader, err := r.file.Open(servedPath)
+			if err != nil {
+				ctx.SetErr(err)
+				return
+			}
+			defer reader.Close()
+
+			// Range, If-None-Match, If-Modified-Since and If-Range are
+			// answered from the headers above
+			if rs, ok := reader.(io.ReadSeeker); ok {
+				ctx.ServeContent(rs, path.Base(servedPath), info.ModTime)
+				return
+			}
+			ctx.ContentType(mime.TypeByExtension(path.Ext(servedPath)))
+			ctx.Header("Accept-Ranges", "none")
+			io.Copy(ctx, reader)
 		})
 
 		{
 			requireUserRouter := router.Party("/", auth.RequireUser)
 
 			requireUserRouter.Post("/upload", func(ctx iris.Context) {
+				claims := ctx.Values().Get(auth.KeyUserClaims).(*jwt.UserClaims)
 				files, infos, err := ctx.FormFiles("files")
 				if err != nil {
 					ctx.SetErr(apperror.BadRequest("Upload file failed", nil, err))
 					return
 				}
 
-				var data []map[string]string
-				for i, file := range files {
+				for _, file := range files {
 					defer file.Close()
+				}
+
+				var size int64
+				for _, info := range infos {
+					size += info.Size
+				}
+
+				// Files saved before a failure are not tracked, the janitor
+				// deletes them
+				err = database.WithTx(ctx, r.client, func(tx *ent.Tx) error {
+					err := r.quota.Check(ctx, tx.Client(), &CheckQuotaParams{
+						UserId: claims.UserId,
+						Size:   size,
+					})
+					if err != nil {
+						return err
+					}
+
+					var data []*uploadResult
+					for i, file := range files {
+						src, err := r.file.SaveToTemporary(file, infos[i])
+						if err != nil {
+							return err
+						}
+
+						res, err := r.uploadResult(ctx, tx.Client(), claims.UserId, src, infos[i].Filename)
+						if err != nil {
+							return err
+						}
+
+						data = append(data, res)
+					}
+
+					ctx.JSON(result.Success("Upload file success", data))
+					return nil
+				})
+
+				if err != nil {
+					ctx.SetErr(err)
+				}
+			})
+
+			requireUserRouter.Post("/upload/resumable", validation.Validate[createUploadBody](validation.ReadBody), func(ctx iris.Context) {
+				body := ctx.Values().Get(string(validation.ReadBody)).(*createUploadBody)
+				claims := ctx.Values().Get(auth.KeyUserClaims).(*jwt.UserClaims)
+				err := database.WithTx(ctx, r.client, func(tx *ent.Tx) error {
+					res, err := r.upload.CreateUpload(ctx, tx.Client(), &CreateUploadParams{
+						UserId: claims.UserId,
+						Name:   body.Name,
+						Size:   body.Size,
+					})
+					if err != nil {
+						return err
+					}
+
+					ctx.JSON(result.Success("Create upload success", res))
+					return nil
+				})
+
+				if err != nil {
+					ctx.SetErr(err)
+				}
+			})
+
+			requireUserRouter.Head("/upload/resumable/{uploadId}", validation.Validate[resumableUploadParams](validation.ReadParams), func(ctx iris.Context) {
+				params := ctx.Values().Get(string(validation.ReadParams)).(*resumableUploadParams)
+				claims := ctx.Values().Get(auth.KeyUserClaims).(*jwt.UserClaims)
+				res, err := r.upload.GetUpload(ctx, r.client, &GetUploadParams{
+					UserId:   claims.UserId,
+					UploadId: params.UploadId,
+				})
+
+				if err != nil {
+					ctx.SetErr(err)
+					return
+				}
+
+				setUploadHeaders(ctx, res)
+			})
+
+			requireUserRouter.Patch("/upload/resumable/{uploadId}", validation.Validate[resumableUploadParams](validation.ReadParams), validation.Validate[appendUploadHeaders](validation.ReadHeaders), func(ctx iris.Context) {
+				params := ctx.Values().Get(string(validation.ReadParams)).(*resumableUploadParams)
+				headers := ctx.Values().Get(string(validation.ReadHeaders)).(*appendUploadHeaders)
+				claims := ctx.Values().Get(auth.KeyUserClaims).(*jwt.UserClaims)
+				res, err := r.upload.AppendUpload(ctx, r.client, &AppendUploadParams{
+					UserId:   claims.UserId,
+					UploadId: params.UploadId,
+					Offset:   headers.Offset,
+					Content:  ctx.Request().Body,
+				})
+
+				if err != nil {
+					ctx.SetErr(err)
+					return
+				}
+
+				setUploadHeaders(ctx, res)
+				ctx.JSON(result.Success("", res))
+			})
 
-					src, err := r.file.SaveToTemporary(file, infos[i])
+			requireUserRouter.Post("/upload/resumable/{uploadId}/complete", validation.Validate[resumableUploadParams](validation.ReadParams), func(ctx iris.Context) {
+				params := ctx.Values().Get(string(validation.ReadParams)).(*resumableUploadParams)
+				claims := ctx.Values().Get(auth.KeyUserClaims).(*jwt.UserClaims)
+				err := database.WithTx(ctx, r.client, func(tx *ent.Tx) error {
+					u, err := r.upload.GetUpload(ctx, tx.Client(), &GetUploadParams{
+						UserId:   claims.UserId,
+						UploadId: params.UploadId,
+					})
 					if err != nil {
-						ctx.SetErr(err)
-						return
+						return err
 					}
 
-					data = append(data, map[string]string{
-						"src":  src,
-						"name": infos[i].Filename,
+					src, err := r.upload.CompleteUpload(ctx, tx.Client(), &CompleteUploadParams{
+						UserId:   claims.UserId,
+						UploadId: params.UploadId,
 					})
+					if err != nil {
+						return err
+					}
+
+					res, err := r.uploadResult(ctx, tx.Client(), claims.UserId, src, u.Name)
+					if err != nil {
+						return err
+					}
+
+					ctx.JSON(result.Success("Upload file success", res))
+					return nil
+				})
+
+				if err != nil {
+					ctx.SetErr(err)
+				}
+			})
+
+			requireUserRouter.Delete("/upload/resumable/{uploadId}", validation.Validate[resumableUploadParams](validation.ReadParams), func(ctx iris.Context) {
+				params := ctx.Values().Get(string(validation.ReadParams)).(*resumableUploadParams)
+				claims := ctx.Values().Get(auth.KeyUserClaims).(*jwt.UserClaims)
+				err := database.WithTx(ctx, r.client, func(tx *ent.Tx) error {
+					return r.upload.DeleteUpload(ctx, tx.Client(), &DeleteUploadParams{
+						UserId:   claims.UserId,
+						UploadId: params.UploadId,
+					})
+				})
+
+				if err != nil {
+					ctx.SetErr(err)
+					return
 				}
 
-				ctx.JSON(result.Success("Upload file success", data))
+				ctx.JSON(result.Success("Cancel upload success", nil))
 			})
 		}
 	}
 }
 
+// uploadResult describes a file stored in the temporary folder, as returned
+// by uploads, and counts it towards the quota of its uploader.
+func (r *Router) uploadResult(ctx context.Context, client *ent.Client, userId int, src, name string) (*uploadResult, error) {
+	filePath := path.Join(temporaryFolderName, src)
+	metadata, err := r.file.Inspect(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	err = r.quota.TrackTemporary(ctx, client, &TrackTemporaryParams{
+		UserId:   userId,
+		Name:     src,
+		Size:     metadata.Size,
+		MimeType: metadata.MimeType,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &uploadResult{
+		Src:      src,
+		Name:     name,
+		Url:      r.file.Sign(filePath, name),
+		Metadata: metadata,
+	}, nil
+}
+
+// setUploadHeaders reports the progress of a resumable upload the way tus
+// does, so clients can resume from Upload-Offset.
+func setUploadHeaders(ctx iris.Context, u *ent.Upload) {
+	ctx.Header("Upload-Offset", strconv.FormatInt(u.ReceivedSize, 10))
+	ctx.Header("Upload-Length", strconv.FormatInt(u.Size, 10))
+	ctx.Header("Cache-Control", "no-store")
+}
+
+type uploadResult struct {
+	Src  string `json:"src"`
+	Name string `json:"name"`
+	Url  string `json:"url"`
+	*Metadata
+}
+
 type getClientFileParams struct {
 	FolderName string `params:"folderName" validate:"required"`
 	FileName   string `params:"fileName" validate:"required"`
 }
+
+type getClientFileQuery struct {
+	Expires   int64  `query:"expires"`
+	Signature string `query:"signature"`
+	Name      string `query:"name"`
+	Width     int    `query:"w" validate:"min=0"`
+	Download  bool   `query:"download"`
+}
+
+type createUploadBody struct {
+	Name string `json:"name" validate:"required,max=255"`
+	Size int64  `json:"size" validate:"required,min=1"`
+}
+
+type resumableUploadParams struct {
+	UploadId int `param:"uploadId" validate:"required"`
+}
+
+type appendUploadHeaders struct {
+	Offset int64 `header:"Upload-Offset" validate:"min=0"`
+}
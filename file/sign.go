@@ -0,0 +1,63 @@
+package file
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// publicFolders are served to anyone, every other folder needs a signed url.
+var publicFolders = map[string]bool{
+	FolderUser:        true,
+	FolderLinkPreview: true,
+}
+
+// Sign returns filePath with an expiring signature appended as query, e.g.
+// "message_media/x.jpg?expires=1700000000&signature=...", so only callers the
+// path was issued to can download it. name is the file name downloads are
+// served with, signed along so it cannot be swapped for another. Public files
+// are returned unchanged.
+func (f *file) Sign(filePath, name string) string {
+	if filePath == "" || IsPublic(filePath) {
+		return filePath
+	}
+
+	expires := time.Now().Add(f.urlExpiresIn).Unix()
+	query := url.Values{
+		"expires":   {strconv.FormatInt(expires, 10)},
+		"signature": {f.signature(filePath, name, expires)},
+	}
+	if name != "" {
+		query.Set("name", name)
+	}
+	return filePath + "?" + query.Encode()
+}
+
+// Verify reports whether signature was issued by Sign for filePath and name
+// and has not expired yet.
+func (f *file) Verify(filePath, name string, expires int64, signature string) bool {
+	if time.Now().Unix() > expires {
+		return false
+	}
+	return hmac.Equal([]byte(signature), []byte(f.signature(filePath, name, expires)))
+}
+
+func (f *file) signature(filePath, name string, expires int64) string {
+	h := hmac.New(sha256.New, f.urlSecretKey)
+	h.Write([]byte(filePath))
+	h.Write([]byte{0})
+	h.Write([]byte(name))
+	h.Write([]byte{0})
+	h.Write([]byte(strconv.FormatInt(expires, 10)))
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+}
+
+// IsPublic reports whether filePath lives in a folder served without a
+// signature.
+func IsPublic(filePath string) bool {
+	return publicFolders[strings.SplitN(filePath, "/", 2)[0]]
+}
@@ -0,0 +1,74 @@
+package file
+
+import (
+	"backend/config"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"go.uber.org/fx"
+)
+
+var (
+	ErrNotExist   = errors.New("File does not exist")
+	ErrInvalidKey = errors.New("Invalid file key")
+)
+
+// Storage is the backend files are kept in. Keys are slash separated paths
+// relative to the storage root, e.g. "message_media/x.jpg", which is also what
+// is stored in the database.
+type Storage interface {
+	// Save writes reader to key. size is -1 when it is not known up front.
+	Save(key string, reader io.Reader, size int64) error
+	Open(key string) (io.ReadCloser, error)
+	Stat(key string) (*FileInfo, error)
+	Move(sourceKey, destinationKey string) error
+	Delete(key string) error
+	// List returns the files directly under prefix.
+	List(prefix string) ([]*FileInfo, error)
+	// URL returns a time limited download url, or an empty string when the
+	// storage cannot be reached by clients and files are served by the API.
+	// The download is served with contentDisposition when not empty.
+	URL(key, contentDisposition string) (string, error)
+}
+
+type FileInfo struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+}
+
+type storageParams struct {
+	fx.In
+	Env *config.Env
+}
+
+func newStorage(p storageParams) (Storage, error) {
+	switch p.Env.StorageDriver {
+	case "local":
+		return newLocalStorage(clientRootPath), nil
+	case "s3":
+		return newS3Storage(&s3Config{
+			endpoint:         p.Env.S3Endpoint,
+			region:           p.Env.S3Region,
+			bucket:           p.Env.S3Bucket,
+			accessKeyId:      p.Env.S3AccessKeyId,
+			secretAccessKey:  p.Env.S3SecretAccessKey,
+			usePathStyle:     p.Env.S3UsePathStyle,
+			presignExpiresIn: p.Env.S3PresignExpiresIn,
+		})
+	default:
+		return nil, errors.Newf("unknown storage driver %q", p.Env.StorageDriver)
+	}
+}
+
+// cleanKey normalises key and refuses keys escaping the storage root.
+func cleanKey(key string) (string, error) {
+	cleaned := path.Clean(strings.ReplaceAll(key, "\\", "/"))
+	if cleaned == "." || cleaned == ".." || strings.HasPrefix(cleaned, "/") || strings.HasPrefix(cleaned, "../") {
+		return "", errors.Wrap(ErrInvalidKey, key)
+	}
+	return cleaned, nil
+}
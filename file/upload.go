@@ -0,0 +1,349 @@
+package file
+
+import (
+	"backend/apperror"
+	"backend/config"
+	"backend/database"
+	"backend/database/ent"
+	"backend/database/ent/upload"
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strconv"
+	"time"
+
+	"entgo.io/ent/dialect/sql"
+	"github.com/cockroachdb/errors"
+	"github.com/google/uuid"
+	"go.uber.org/fx"
+)
+
+// Upload implements resumable uploads: a client creates an upload with the
+// final size, sends the content in chunks at increasing offsets, and can ask
+// for the received offset to resume after a network failure. Completing the
+// upload assembles the chunks into the temporary folder, as /upload would.
+type Upload struct {
+	file    File
+	storage Storage
+	quota   *Quota
+}
+
+type uploadParams struct {
+	fx.In
+	File    File
+	Storage Storage
+	Quota   *Quota
+}
+
+func newUpload(p uploadParams) *Upload {
+	return &Upload{
+		file:    p.File,
+		storage: p.Storage,
+		quota:   p.Quota,
+	}
+}
+
+func (s *Upload) CreateUpload(ctx context.Context, client *ent.Client, p *CreateUploadParams) (*ent.Upload, error) {
+	if err := s.file.ValidateTemporary(p.Name, p.Size); err != nil {
+		return nil, err
+	}
+	err := s.quota.Check(ctx, client, &CheckQuotaParams{
+		UserId: p.UserId,
+		Size:   p.Size,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := client.Upload.Create().
+		SetUserID(p.UserId).
+		SetName(p.Name).
+		SetSize(p.Size).
+		SetExpiresAt(uploadExpiresAt()).
+		Save(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "Upload.Create failed")
+	}
+
+	return res, nil
+}
+
+func (s *Upload) GetUpload(ctx context.Context, client *ent.Client, p *GetUploadParams) (*ent.Upload, error) {
+	res, err := client.Upload.
+		Query().
+		Where(
+			upload.ID(p.UploadId),
+			upload.UserId(p.UserId),
+			upload.ExpiresAtGT(time.Now()),
+		).
+		First(ctx)
+	if err != nil && !ent.IsNotFound(err) {
+		return nil, errors.Wrap(err, "Query failed")
+	}
+	if res == nil {
+		return nil, apperror.NotFound(messageUploadNotFound, nil, nil)
+	}
+
+	return res, nil
+}
+
+// AppendUpload stores a chunk received at p.Offset, which must be the offset
+// the upload is at. Content beyond the declared size is refused, so the
+// folder size limit checked on creation holds. The chunk is received before
+// the upload is locked, a slow client must not hold a transaction open.
+func (s *Upload) AppendUpload(ctx context.Context, client *ent.Client, p *AppendUploadParams) (*ent.Upload, error) {
+	u, err := s.GetUpload(ctx, client, &GetUploadParams{
+		UserId:   p.UserId,
+		UploadId: p.UploadId,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if p.Offset != u.ReceivedSize {
+		return nil, offsetMismatch(u)
+	}
+
+	remaining := u.Size - u.ReceivedSize
+	content := &countingReader{reader: io.LimitReader(p.Content, remaining+1)}
+	pendingKey := path.Join(uploadPrefix(u.ID), pendingFolderName, uuid.NewString())
+	if err := s.storage.Save(pendingKey, content, -1); err != nil {
+		return nil, err
+	}
+	if content.count > remaining {
+		s.storage.Delete(pendingKey)
+		return nil, apperror.BadRequest(errors.Errorf("%w: upload size is %d bytes", ErrFileTooLarge, u.Size).Error(), nil, nil)
+	}
+	if content.count == 0 {
+		s.storage.Delete(pendingKey)
+		return u, nil
+	}
+
+	err = database.WithTx(ctx, client, func(tx *ent.Tx) error {
+		u, err = s.lockUpload(ctx, tx.Client(), p.UserId, p.UploadId)
+		if err != nil {
+			return err
+		}
+		// Another request stored a chunk at this offset in the meantime
+		if p.Offset != u.ReceivedSize {
+			return offsetMismatch(u)
+		}
+
+		if err := s.storage.Move(pendingKey, chunkKey(u.ID, u.ReceivedSize)); err != nil {
+			return err
+		}
+
+		u, err = u.Update().
+			AddReceivedSize(content.count).
+			SetExpiresAt(uploadExpiresAt()).
+			Save(ctx)
+		if err != nil {
+			return errors.Wrap(err, "Upload.Update failed")
+		}
+		return nil
+	})
+	if err != nil {
+		s.storage.Delete(pendingKey)
+		return nil, err
+	}
+
+	return u, nil
+}
+
+// CompleteUpload assembles a fully received upload into the temporary folder
+// and returns its name there.
+func (s *Upload) CompleteUpload(ctx context.Context, client *ent.Client, p *CompleteUploadParams) (string, error) {
+	u, err := s.lockUpload(ctx, client, p.UserId, p.UploadId)
+	if err != nil {
+		return "", err
+	}
+
+	if u.ReceivedSize != u.Size {
+		return "", apperror.Conflict(messageUploadIncomplete, map[string]int64{
+			"offset": u.ReceivedSize,
+		}, nil)
+	}
+
+	chunks, err := s.storage.List(uploadPrefix(u.ID))
+	if err != nil {
+		return "", err
+	}
+	keys := make([]string, len(chunks))
+	for i, c := range chunks {
+		keys[i] = c.Key
+	}
+	// Keys are zero padded offsets, so they sort in content order
+	sort.Strings(keys)
+
+	content := &chunkReader{storage: s.storage, keys: keys}
+	defer content.Close()
+
+	src, err := s.file.SaveReaderToTemporary(content, u.Name, u.Size)
+	if err != nil {
+		return "", err
+	}
+
+	if err := client.Upload.DeleteOne(u).Exec(ctx); err != nil {
+		return "", errors.Wrap(err, "Upload.Delete failed")
+	}
+	s.deleteChunks(keys)
+
+	return src, nil
+}
+
+func (s *Upload) DeleteUpload(ctx context.Context, client *ent.Client, p *DeleteUploadParams) error {
+	u, err := s.lockUpload(ctx, client, p.UserId, p.UploadId)
+	if err != nil {
+		return err
+	}
+
+	if err := client.Upload.DeleteOne(u).Exec(ctx); err != nil {
+		return errors.Wrap(err, "Upload.Delete failed")
+	}
+
+	return s.deleteUploadChunks(u.ID)
+}
+
+// lockUpload locks the upload so chunks of one upload are stored one at a
+// time.
+func (s *Upload) lockUpload(ctx context.Context, client *ent.Client, userId, uploadId int) (*ent.Upload, error) {
+	queryBuilder := client.Upload.
+		Query().
+		Where(
+			upload.ID(uploadId),
+			upload.UserId(userId),
+			upload.ExpiresAtGT(time.Now()),
+		)
+	queryBuilder.Modify(func(s *sql.Selector) {
+		s.ForUpdate()
+	})
+
+	res, err := queryBuilder.First(ctx)
+	if err != nil && !ent.IsNotFound(err) {
+		return nil, errors.Wrap(err, "Query failed")
+	}
+	if res == nil {
+		return nil, apperror.NotFound(messageUploadNotFound, nil, nil)
+	}
+
+	return res, nil
+}
+
+// deleteUploadChunks removes the chunks of an upload, including ones still
+// being received.
+func (s *Upload) deleteUploadChunks(uploadId int) error {
+	for _, prefix := range []string{uploadPrefix(uploadId), path.Join(uploadPrefix(uploadId), pendingFolderName)} {
+		chunks, err := s.storage.List(prefix)
+		if err != nil {
+			return err
+		}
+		keys := make([]string, len(chunks))
+		for i, c := range chunks {
+			keys[i] = c.Key
+		}
+		s.deleteChunks(keys)
+	}
+	return nil
+}
+
+func (s *Upload) deleteChunks(keys []string) {
+	for _, key := range keys {
+		// The upload row is gone, a leftover chunk is harmless
+		_ = s.storage.Delete(key)
+	}
+}
+
+func offsetMismatch(u *ent.Upload) error {
+	return apperror.Conflict(messageUploadOffsetMismatch, map[string]int64{
+		"offset": u.ReceivedSize,
+	}, nil)
+}
+
+func uploadExpiresAt() time.Time {
+	return time.Now().Add(config.UploadExpiresInHour * time.Hour)
+}
+
+func uploadPrefix(uploadId int) string {
+	return path.Join(uploadFolderName, strconv.Itoa(uploadId))
+}
+
+func chunkKey(uploadId int, offset int64) string {
+	return path.Join(uploadPrefix(uploadId), fmt.Sprintf("%020d", offset))
+}
+
+// chunkReader reads stored chunks one after the other, opening each only
+// when the previous one is exhausted.
+type chunkReader struct {
+	storage Storage
+	keys    []string
+	current io.ReadCloser
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	for {
+		if r.current == nil {
+			if len(r.keys) == 0 {
+				return 0, io.EOF
+			}
+			current, err := r.storage.Open(r.keys[0])
+			if err != nil {
+				return 0, err
+			}
+			r.current = current
+			r.keys = r.keys[1:]
+		}
+
+		n, err := r.current.Read(p)
+		if errors.Is(err, io.EOF) {
+			r.current.Close()
+			r.current = nil
+			if n == 0 {
+				continue
+			}
+			return n, nil
+		}
+		return n, err
+	}
+}
+
+func (r *chunkReader) Close() error {
+	if r.current == nil {
+		return nil
+	}
+	return r.current.Close()
+}
+
+const (
+	messageUploadNotFound       = "Upload not found or expired"
+	messageUploadOffsetMismatch = "Upload offset does not match the received size"
+	messageUploadIncomplete     = "Upload is not complete"
+)
+
+type CreateUploadParams struct {
+	UserId int
+	Name   string
+	Size   int64
+}
+
+type GetUploadParams struct {
+	UserId   int
+	UploadId int
+}
+
+type AppendUploadParams struct {
+	UserId   int
+	UploadId int
+	Offset   int64
+	Content  io.Reader
+}
+
+type CompleteUploadParams struct {
+	UserId   int
+	UploadId int
+}
+
+type DeleteUploadParams struct {
+	UserId   int
+	UploadId int
+}
@@ -0,0 +1,135 @@
+package file
+
+import (
+	"backend/config"
+	"backend/database"
+	"backend/database/ent"
+	"backend/database/ent/upload"
+	"backend/logger"
+	"context"
+	"time"
+
+	"entgo.io/ent/dialect/sql"
+	"github.com/cockroachdb/errors"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+)
+
+// UploadReaper deletes resumable uploads abandoned by their client, together
+// with the chunks received so far.
+type UploadReaper struct {
+	client *ent.Client
+	upload *Upload
+	logger *logger.Logger
+}
+
+type uploadReaperParams struct {
+	fx.In
+	fx.Lifecycle
+	Client *ent.Client
+	Upload *Upload
+	Logger *logger.Logger
+}
+
+func newUploadReaper(p uploadReaperParams) *UploadReaper {
+	r := &UploadReaper{
+		client: p.Client,
+		upload: p.Upload,
+		logger: p.Logger,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	p.Lifecycle.Append(fx.Hook{
+		OnStart: func(context.Context) error {
+			go func() {
+				defer close(done)
+				r.run(ctx)
+			}()
+			return nil
+		},
+		OnStop: func(stopCtx context.Context) error {
+			cancel()
+			select {
+			case <-done:
+			case <-stopCtx.Done():
+			}
+			return nil
+		},
+	})
+
+	return r
+}
+
+func (r *UploadReaper) run(ctx context.Context) {
+	ticker := time.NewTicker(config.UploadReaperIntervalInSecond * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.reap(ctx)
+		}
+	}
+}
+
+func (r *UploadReaper) reap(ctx context.Context) {
+	for ctx.Err() == nil {
+		count, err := r.reapBatch(ctx)
+		if err != nil {
+			r.logger.Error("Reap expired uploads failed", zap.Error(err))
+			return
+		}
+		if count < config.UploadReaperBatchSize {
+			return
+		}
+	}
+}
+
+func (r *UploadReaper) reapBatch(ctx context.Context) (int, error) {
+	var expired []*ent.Upload
+	err := database.WithTx(ctx, r.client, func(tx *ent.Tx) error {
+		queryBuilder := tx.Upload.
+			Query().
+			Where(upload.ExpiresAtLTE(time.Now())).
+			Order(ent.Asc(upload.FieldExpiresAt)).
+			Limit(config.UploadReaperBatchSize)
+		queryBuilder.Modify(func(s *sql.Selector) {
+			s.ForUpdate(sql.WithLockAction(sql.SkipLocked))
+		})
+
+		var err error
+		expired, err = queryBuilder.All(ctx)
+		if err != nil {
+			return errors.Wrap(err, "Query failed")
+		}
+		if len(expired) == 0 {
+			return nil
+		}
+
+		ids := make([]int, len(expired))
+		for i, u := range expired {
+			ids[i] = u.ID
+		}
+		if _, err := tx.Upload.Delete().Where(upload.IDIn(ids...)).Exec(ctx); err != nil {
+			return errors.Wrap(err, "Upload.Delete failed")
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	// Chunks go after the commit, a failure leaves files behind, not rows
+	// pointing at missing chunks
+	for _, u := range expired {
+		if err := r.upload.deleteUploadChunks(u.ID); err != nil {
+			r.logger.Warn("Delete upload chunks failed", zap.Int("uploadId", u.ID), zap.Error(err))
+		}
+	}
+
+	return len(expired), nil
+}
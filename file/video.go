@@ -0,0 +1,104 @@
+package file
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+// maxBoxes bounds how many boxes are walked, so a crafted file cannot keep
+// the parser busy.
+const maxBoxes = 1024
+
+// mp4Duration reads the duration in seconds from the movie header of an ISO
+// base media file (MP4, MOV, 3GP...). Boxes are skipped by seeking, since the
+// movie box is often stored after the media data.
+func mp4Duration(r io.ReadSeeker) (float64, bool) {
+	end, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, false
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return 0, false
+	}
+
+	var offset int64
+	for i := 0; i < maxBoxes && offset < end; i++ {
+		boxType, size, headerSize, ok := readBoxHeader(r, end-offset)
+		if !ok {
+			return 0, false
+		}
+
+		switch boxType {
+		case "moov":
+			// Descend into the movie box
+			offset += headerSize
+			continue
+		case "mvhd":
+			return readMovieHeader(r)
+		}
+
+		offset += size
+		if _, err := r.Seek(offset, io.SeekStart); err != nil {
+			return 0, false
+		}
+	}
+
+	return 0, false
+}
+
+func readBoxHeader(r io.Reader, remaining int64) (string, int64, int64, bool) {
+	var header [8]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return "", 0, 0, false
+	}
+
+	size := int64(binary.BigEndian.Uint32(header[:4]))
+	headerSize := int64(8)
+	switch size {
+	case 0:
+		// Box extends to the end of the file
+		size = remaining
+	case 1:
+		var largeSize [8]byte
+		if _, err := io.ReadFull(r, largeSize[:]); err != nil {
+			return "", 0, 0, false
+		}
+		size = int64(binary.BigEndian.Uint64(largeSize[:]))
+		headerSize = 16
+	}
+	if size < headerSize || size > remaining {
+		return "", 0, 0, false
+	}
+
+	return string(header[4:]), size, headerSize, true
+}
+
+func readMovieHeader(r io.Reader) (float64, bool) {
+	var version [4]byte
+	if _, err := io.ReadFull(r, version[:]); err != nil {
+		return 0, false
+	}
+
+	var timescale uint32
+	var duration uint64
+	if version[0] == 1 {
+		var body [28]byte // creation, modification, timescale, duration
+		if _, err := io.ReadFull(r, body[:]); err != nil {
+			return 0, false
+		}
+		timescale = binary.BigEndian.Uint32(body[16:20])
+		duration = binary.BigEndian.Uint64(body[20:28])
+	} else {
+		var body [16]byte
+		if _, err := io.ReadFull(r, body[:]); err != nil {
+			return 0, false
+		}
+		timescale = binary.BigEndian.Uint32(body[8:12])
+		duration = uint64(binary.BigEndian.Uint32(body[12:16]))
+	}
+	if timescale == 0 {
+		return 0, false
+	}
+
+	return float64(duration) / float64(timescale), true
+}
@@ -50,6 +50,10 @@ func newErrorHandler(p errorHandlerParams) ErrorHandler {
 						ctx.StatusCode(http.StatusUnauthorized)
 					case apperror.CodeForbidden:
 						ctx.StatusCode(http.StatusForbidden)
+					case apperror.CodeConflict:
+						ctx.StatusCode(http.StatusConflict)
+					case apperror.CodeQuotaExceeded:
+						ctx.StatusCode(http.StatusRequestEntityTooLarge)
 					}
 
 					if errors.As(err, &validationErrors) {
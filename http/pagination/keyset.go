@@ -0,0 +1,51 @@
+package pagination
+
+import (
+	"encoding/base64"
+	"encoding/json"
+)
+
+type KeysetQuery struct {
+	Limit  int    `query:"limit"  validate:"gte=0,lte=100"`
+	Cursor string `query:"cursor"`
+}
+
+type KeysetResult[T any] struct {
+	Rows       []T    `json:"rows"`
+	Limit      int    `json:"limit"`
+	NextCursor string `json:"nextCursor"`
+}
+
+func (p *KeysetQuery) normalize() {
+	if p.Limit <= 0 {
+		p.Limit = 10
+	}
+	if p.Limit > 100 {
+		p.Limit = 100
+	}
+}
+
+func (p *KeysetQuery) GetLimit() int {
+	p.normalize()
+	return p.Limit
+}
+
+// EncodeCursor serialises the keyset of the last returned row into an opaque cursor.
+func EncodeCursor(v any) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// DecodeCursor is the inverse of EncodeCursor.
+func DecodeCursor(cursor string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,208 @@
+package linkpreview
+
+import (
+	"backend/file"
+	"context"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/cockroachdb/errors"
+)
+
+const (
+	fetchTimeout  = 5 * time.Second
+	maxPageBytes  = 1024 * 1024     // 1 MB, metadata lives in the head
+	maxImageBytes = 5 * 1024 * 1024 // 5 MB
+	maxRedirects  = 3
+	userAgent     = "Mozilla/5.0 (compatible; ChatLinkPreview/1.0)"
+)
+
+var (
+	ErrBlockedAddress     = errors.New("Address is not allowed")
+	ErrUnsupportedURL     = errors.New("Only http and https urls are supported")
+	ErrUnexpectedResponse = errors.New("Unexpected response")
+	ErrImageTooLarge      = errors.New("Image is too large")
+)
+
+// Special purpose ranges not covered by the net.IP helpers.
+var blockedNetworks = mustParseCIDRs(
+	"0.0.0.0/8",       // "this" network
+	"100.64.0.0/10",   // carrier-grade NAT
+	"192.0.0.0/24",    // IETF protocol assignments
+	"192.0.2.0/24",    // documentation
+	"198.18.0.0/15",   // benchmarking
+	"198.51.100.0/24", // documentation
+	"203.0.113.0/24",  // documentation
+	"240.0.0.0/4",     // reserved
+	"64:ff9b::/96",    // NAT64, may map to a private IPv4 address
+	"2001:db8::/32",   // documentation
+)
+
+// newHTTPClient returns a client that only connects to addresses allowIP
+// accepts, isPublicIP outside of tests. The check runs on the resolved address
+// at dial time, so hostnames resolving (or rebinding) to private ranges and
+// redirects to them are refused as well. Each step of a fetch is bounded by
+// timeout, the whole fetch by twice as much.
+func newHTTPClient(allowIP func(net.IP) bool, timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil || !allowIP(ip) {
+				return errors.Wrap(ErrBlockedAddress, host)
+			}
+			return nil
+		},
+	}
+
+	transport := &http.Transport{
+		// No proxy, the address check must see the real destination
+		Proxy:                 nil,
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   timeout,
+		ResponseHeaderTimeout: timeout,
+		MaxIdleConns:          10,
+		IdleConnTimeout:       30 * time.Second,
+	}
+
+	return &http.Client{
+		Timeout:   2 * timeout,
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxRedirects {
+				return errors.New("Too many redirects")
+			}
+			return validateURL(req.URL)
+		},
+	}
+}
+
+func isPublicIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return false
+	}
+	for _, network := range blockedNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+func validateURL(u *url.URL) error {
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" || u.User != nil {
+		return ErrUnsupportedURL
+	}
+	return nil
+}
+
+func (l *LinkPreview) get(ctx context.Context, rawURL, accept string) (*http.Response, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, errors.Wrap(ErrUnsupportedURL, err.Error())
+	}
+	if err := validateURL(u); err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "NewRequest failed")
+	}
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("Accept", accept)
+
+	resp, err := l.httpClient.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "Fetch failed")
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		resp.Body.Close()
+		return nil, errors.Wrapf(ErrUnexpectedResponse, "status %d", resp.StatusCode)
+	}
+
+	return resp, nil
+}
+
+func (l *LinkPreview) fetchMetadata(ctx context.Context, rawURL string) (*metadata, error) {
+	resp, err := l.get(ctx, rawURL, "text/html,application/xhtml+xml")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType != "text/html" && mediaType != "application/xhtml+xml" {
+		return nil, errors.Wrapf(ErrUnexpectedResponse, "content type %q", mediaType)
+	}
+
+	// Relative image urls resolve against the final url after redirects
+	return parseMetadata(io.LimitReader(resp.Body, maxPageBytes), resp.Request.URL), nil
+}
+
+func (l *LinkPreview) saveImage(ctx context.Context, imageURL string) (string, error) {
+	resp, err := l.get(ctx, imageURL, "image/*")
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	extension, ok := imageExtensions[strings.ToLower(mediaType)]
+	if !ok {
+		return "", errors.Wrapf(ErrUnexpectedResponse, "content type %q", mediaType)
+	}
+
+	if resp.ContentLength > maxImageBytes {
+		return "", errors.Wrapf(ErrImageTooLarge, "%d bytes", resp.ContentLength)
+	}
+
+	// The length may be missing or wrong, the body is bounded while saved
+	return l.file.SaveReader(&cappedReader{reader: resp.Body, remaining: maxImageBytes}, extension, file.FolderLinkPreview)
+}
+
+// cappedReader fails once more than remaining bytes are read through it.
+type cappedReader struct {
+	reader    io.Reader
+	remaining int64
+}
+
+func (r *cappedReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.remaining -= int64(n)
+	if r.remaining < 0 {
+		return n, ErrImageTooLarge
+	}
+	return n, err
+}
+
+var imageExtensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, len(cidrs))
+	for i, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks[i] = network
+	}
+	return networks
+}
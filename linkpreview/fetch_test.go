@@ -0,0 +1,265 @@
+package linkpreview
+
+import (
+	"backend/file"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/errors"
+)
+
+const testTimeout = 200 * time.Millisecond
+
+// fakeFile keeps the content saved through it in memory.
+type fakeFile struct {
+	file.File
+	saved map[string][]byte
+}
+
+func (f *fakeFile) SaveReader(reader io.Reader, extension, folderName string) (string, error) {
+	content, err := io.ReadAll(reader)
+	if err != nil {
+		return "", err
+	}
+	filePath := fmt.Sprintf("%s/%d%s", folderName, len(f.saved), extension)
+	f.saved[filePath] = content
+	return filePath, nil
+}
+
+// newTestLinkPreview returns a LinkPreview allowed to reach the loopback
+// address httptest servers listen on, and nothing else.
+func newTestLinkPreview() (*LinkPreview, *fakeFile) {
+	f := &fakeFile{saved: map[string][]byte{}}
+	return &LinkPreview{
+		file: f,
+		httpClient: newHTTPClient(func(ip net.IP) bool {
+			return ip.Equal(net.IPv4(127, 0, 0, 1))
+		}, testTimeout),
+	}, f
+}
+
+func serveHTML(t *testing.T, page string) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		io.WriteString(w, page)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestFetchMetadataOpenGraph(t *testing.T) {
+	server := serveHTML(t, `<html><head>
+		<title>Fallback title</title>
+		<meta property="og:title" content="OpenGraph title">
+		<meta property="og:description" content="OpenGraph description">
+		<meta property="og:site_name" content="Example">
+		<meta property="og:image" content="/images/cover.png">
+		<meta name="twitter:title" content="Twitter title">
+	</head><body></body></html>`)
+
+	l, _ := newTestLinkPreview()
+	meta, err := l.fetchMetadata(context.Background(), server.URL+"/article")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if meta.title != "OpenGraph title" || meta.description != "OpenGraph description" || meta.siteName != "Example" {
+		t.Fatalf("unexpected metadata %+v", meta)
+	}
+	if meta.image != server.URL+"/images/cover.png" {
+		t.Fatalf("image %q, want it resolved against the page", meta.image)
+	}
+}
+
+func TestFetchMetadataTwitterCard(t *testing.T) {
+	server := serveHTML(t, `<html><head>
+		<title>Fallback title</title>
+		<meta name="twitter:title" content="Twitter title">
+		<meta name="twitter:description" content="Twitter description">
+		<meta name="twitter:image:src" content="https://cdn.example.com/card.jpg">
+	</head></html>`)
+
+	l, _ := newTestLinkPreview()
+	meta, err := l.fetchMetadata(context.Background(), server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if meta.title != "Twitter title" || meta.description != "Twitter description" {
+		t.Fatalf("unexpected metadata %+v", meta)
+	}
+	if meta.image != "https://cdn.example.com/card.jpg" {
+		t.Fatalf("unexpected image %q", meta.image)
+	}
+}
+
+func TestFetchMetadataFallback(t *testing.T) {
+	server := serveHTML(t, `<html><head>
+		<title>  Plain
+		page  </title>
+		<meta name="description" content="Plain description">
+	</head><body><meta property="og:title" content="Not in the head"></body></html>`)
+
+	l, _ := newTestLinkPreview()
+	meta, err := l.fetchMetadata(context.Background(), server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if meta.title != "Plain page" || meta.description != "Plain description" || meta.image != "" {
+		t.Fatalf("unexpected metadata %+v", meta)
+	}
+}
+
+func TestFetchMetadataRejectsOtherContent(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"title": "json"}`)
+	}))
+	defer server.Close()
+
+	l, _ := newTestLinkPreview()
+	if _, err := l.fetchMetadata(context.Background(), server.URL); !errors.Is(err, ErrUnexpectedResponse) {
+		t.Fatalf("got %v, want ErrUnexpectedResponse", err)
+	}
+}
+
+func TestFetchMetadataBodyCap(t *testing.T) {
+	// Tags past the first maxPageBytes are never read
+	server := serveHTML(t, `<html><head><!--`+strings.Repeat("x", maxPageBytes)+`-->
+		<meta property="og:title" content="Too far">
+	</head></html>`)
+
+	l, _ := newTestLinkPreview()
+	meta, err := l.fetchMetadata(context.Background(), server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.title != "" {
+		t.Fatalf("title %q read past the body cap", meta.title)
+	}
+}
+
+func TestFetchTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(10 * testTimeout):
+		}
+	}))
+	defer server.Close()
+
+	l, _ := newTestLinkPreview()
+	start := time.Now()
+	if _, err := l.fetchMetadata(context.Background(), server.URL); err == nil {
+		t.Fatal("fetch did not time out")
+	}
+	if elapsed := time.Since(start); elapsed > 5*testTimeout {
+		t.Fatalf("fetch took %v", elapsed)
+	}
+}
+
+func TestFetchRejectsPrivateAddress(t *testing.T) {
+	server := serveHTML(t, `<html><head><title>Internal</title></head></html>`)
+
+	// The default policy, httptest listens on a loopback address
+	l, _ := newTestLinkPreview()
+	l.httpClient = newHTTPClient(isPublicIP, testTimeout)
+
+	if _, err := l.fetchMetadata(context.Background(), server.URL); !errors.Is(err, ErrBlockedAddress) {
+		t.Fatalf("got %v, want ErrBlockedAddress", err)
+	}
+}
+
+func TestFetchRejectsRedirectToPrivateAddress(t *testing.T) {
+	for _, target := range []string{"http://10.0.0.1/admin", "http://169.254.169.254/latest/meta-data/"} {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Redirect(w, r, target, http.StatusFound)
+		}))
+
+		l, _ := newTestLinkPreview()
+		_, err := l.fetchMetadata(context.Background(), server.URL)
+		server.Close()
+		if !errors.Is(err, ErrBlockedAddress) {
+			t.Fatalf("redirect to %s: got %v, want ErrBlockedAddress", target, err)
+		}
+	}
+}
+
+func TestIsPublicIP(t *testing.T) {
+	for _, c := range []struct {
+		ip     string
+		public bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:2800:220:1:248:1893:25c8:1946", true},
+		{"127.0.0.1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"100.64.0.1", false},
+		{"0.0.0.0", false},
+		{"::1", false},
+		{"fc00::1", false},
+		{"fe80::1", false},
+		{"64:ff9b::a00:1", false},
+	} {
+		if got := isPublicIP(net.ParseIP(c.ip)); got != c.public {
+			t.Errorf("isPublicIP(%s) = %v, want %v", c.ip, got, c.public)
+		}
+	}
+}
+
+func serveImage(t *testing.T, content []byte, contentLength bool) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		if contentLength {
+			w.Header().Set("Content-Length", fmt.Sprint(len(content)))
+		}
+		w.(http.Flusher).Flush()
+		w.Write(content)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestSaveImage(t *testing.T) {
+	content := []byte("\x89PNG\r\n\x1a\nimage")
+	server := serveImage(t, content, true)
+
+	l, f := newTestLinkPreview()
+	filePath, err := l.saveImage(context.Background(), server.URL+"/image.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(filePath, ".png") || !bytes.Equal(f.saved[filePath], content) {
+		t.Fatalf("unexpected saved image %s", filePath)
+	}
+}
+
+func TestSaveImageSizeCap(t *testing.T) {
+	content := bytes.Repeat([]byte{0}, maxImageBytes+1)
+
+	for _, contentLength := range []bool{true, false} {
+		server := serveImage(t, content, contentLength)
+
+		l, f := newTestLinkPreview()
+		if _, err := l.saveImage(context.Background(), server.URL); !errors.Is(err, ErrImageTooLarge) {
+			t.Fatalf("content length %v: got %v, want ErrImageTooLarge", contentLength, err)
+		}
+		if len(f.saved) != 0 {
+			t.Fatalf("content length %v: image saved", contentLength)
+		}
+	}
+}
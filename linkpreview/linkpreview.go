@@ -0,0 +1,98 @@
+package linkpreview
+
+import (
+	"backend/config"
+	"backend/database/ent"
+	"backend/database/ent/linkpreview"
+	"backend/file"
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"go.uber.org/fx"
+)
+
+type LinkPreview struct {
+	file       file.File
+	httpClient *http.Client
+}
+
+type linkPreviewParams struct {
+	fx.In
+	File file.File
+}
+
+func newLinkPreview(p linkPreviewParams) *LinkPreview {
+	return &LinkPreview{
+		file:       p.File,
+		httpClient: newHTTPClient(isPublicIP, fetchTimeout),
+	}
+}
+
+// Get returns the preview of a page, fetching it when it is not cached or the
+// cached copy is stale. Pages that cannot be fetched are cached as empty
+// previews so they are not retried for every message.
+func (l *LinkPreview) Get(ctx context.Context, client *ent.Client, rawURL string) (*ent.LinkPreview, error) {
+	cached, err := client.LinkPreview.Query().Where(linkpreview.URL(rawURL)).First(ctx)
+	if err != nil && !ent.IsNotFound(err) {
+		return nil, errors.Wrap(err, "LinkPreview.Query() failed")
+	}
+	if cached != nil && time.Since(cached.FetchedAt) < config.LinkPreviewCacheInHour*time.Hour {
+		return cached, nil
+	}
+
+	meta, err := l.fetchMetadata(ctx, rawURL)
+	if err != nil {
+		meta = &metadata{}
+	}
+
+	var image string
+	if meta.image != "" {
+		// A broken image should not drop the rest of the preview
+		image, _ = l.saveImage(ctx, meta.image)
+	}
+
+	if cached != nil {
+		preview, err := cached.Update().
+			SetTitle(meta.title).
+			SetDescription(meta.description).
+			SetSiteName(meta.siteName).
+			SetImage(image).
+			SetFetchedAt(time.Now()).
+			Save(ctx)
+		if err != nil {
+			_ = l.file.Delete(ctx, client, image)
+			return nil, errors.Wrap(err, "LinkPreview.Update() failed")
+		}
+		_ = l.file.Delete(ctx, client, cached.Image)
+
+		return preview, nil
+	}
+
+	preview, err := client.LinkPreview.Create().
+		SetURL(rawURL).
+		SetTitle(meta.title).
+		SetDescription(meta.description).
+		SetSiteName(meta.siteName).
+		SetImage(image).
+		SetFetchedAt(time.Now()).
+		Save(ctx)
+	if err != nil {
+		_ = l.file.Delete(ctx, client, image)
+		// Another worker cached the same url in the meantime
+		if ent.IsConstraintError(err) {
+			preview, err = client.LinkPreview.Query().Where(linkpreview.URL(rawURL)).Only(ctx)
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "LinkPreview.Create() failed")
+		}
+	}
+
+	return preview, nil
+}
+
+// IsEmpty reports whether a preview has nothing worth showing.
+func IsEmpty(preview *ent.LinkPreview) bool {
+	return preview.Title == "" && preview.Image == ""
+}
@@ -0,0 +1,7 @@
+package linkpreview
+
+import "go.uber.org/fx"
+
+var Module = fx.Module("linkpreview",
+	fx.Provide(newLinkPreview),
+)
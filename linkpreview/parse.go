@@ -0,0 +1,120 @@
+package linkpreview
+
+import (
+	"io"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+)
+
+type metadata struct {
+	title       string
+	description string
+	siteName    string
+	image       string
+}
+
+// Limits match the link_preview columns.
+const (
+	maxTitleLength       = 300
+	maxDescriptionLength = 1000
+	maxSiteNameLength    = 100
+)
+
+// parseMetadata reads OpenGraph and Twitter card tags from the head of a page,
+// falling back to the title tag and the description meta tag.
+func parseMetadata(r io.Reader, base *url.URL) *metadata {
+	values := make(map[string]string)
+	var title string
+
+	z := html.NewTokenizer(r)
+loop:
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			break loop
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := z.TagName()
+			switch string(name) {
+			case "meta":
+				if !hasAttr {
+					continue
+				}
+				key, content := metaAttributes(z)
+				if _, ok := values[key]; !ok && key != "" && content != "" {
+					values[key] = content
+				}
+			case "title":
+				if title == "" && z.Next() == html.TextToken {
+					title = string(z.Text())
+				}
+			case "body":
+				break loop
+			}
+		case html.EndTagToken:
+			if name, _ := z.TagName(); string(name) == "head" {
+				break loop
+			}
+		}
+	}
+
+	return &metadata{
+		title:       truncate(firstNonEmpty(values["og:title"], values["twitter:title"], title), maxTitleLength),
+		description: truncate(firstNonEmpty(values["og:description"], values["twitter:description"], values["description"]), maxDescriptionLength),
+		siteName:    truncate(values["og:site_name"], maxSiteNameLength),
+		image:       resolveURL(base, firstNonEmpty(values["og:image"], values["og:image:url"], values["twitter:image"], values["twitter:image:src"])),
+	}
+}
+
+func metaAttributes(z *html.Tokenizer) (key, content string) {
+	for {
+		name, value, more := z.TagAttr()
+		switch string(name) {
+		case "property", "name":
+			if key == "" {
+				key = strings.ToLower(strings.TrimSpace(string(value)))
+			}
+		case "content":
+			content = strings.TrimSpace(string(value))
+		}
+		if !more {
+			return key, content
+		}
+	}
+}
+
+func resolveURL(base *url.URL, ref string) string {
+	if ref == "" {
+		return ""
+	}
+	u, err := base.Parse(ref)
+	if err != nil || validateURL(u) != nil {
+		return ""
+	}
+	return u.String()
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// truncate collapses whitespace and cuts s to at most n bytes without
+// splitting a character.
+func truncate(s string, n int) string {
+	s = strings.Join(strings.Fields(s), " ")
+	if len(s) <= n {
+		return s
+	}
+	s = s[:n]
+	for len(s) > 0 && !utf8.ValidString(s) {
+		s = s[:len(s)-1]
+	}
+	return s
+}
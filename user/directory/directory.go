@@ -0,0 +1,173 @@
+package directory
+
+import (
+	"backend/apperror"
+	"backend/common"
+	"backend/database/ent"
+	entpredicate "backend/database/ent/predicate"
+	"backend/database/ent/user"
+	"backend/database/ent/userblock"
+	"backend/database/predicate"
+	"backend/http/pagination"
+	"backend/user/setting"
+	"context"
+	"strings"
+	"unicode/utf8"
+
+	"entgo.io/ent/dialect/sql"
+	"github.com/cockroachdb/errors"
+	"go.uber.org/fx"
+)
+
+const columnRank = "rank"
+
+// minSearchLength is counted after trimming, a blank search would match every
+// fullname.
+const minSearchLength = 2
+
+type Directory struct {
+	setting *setting.Setting
+}
+
+type directoryParams struct {
+	fx.In
+	Setting *setting.Setting
+}
+
+func newDirectory(p directoryParams) *Directory {
+	return &Directory{
+		setting: p.Setting,
+	}
+}
+
+func (d *Directory) Search(ctx context.Context, client *ent.Client, p *SearchParams) (*pagination.KeysetResult[*ent.User], error) {
+	keysetQuery := &pagination.KeysetQuery{
+		Limit:  p.Limit,
+		Cursor: p.Cursor,
+	}
+	limit := keysetQuery.GetLimit()
+
+	var c *searchCursor
+	if keysetQuery.Cursor != "" {
+		c = &searchCursor{}
+		if err := pagination.DecodeCursor(keysetQuery.Cursor, c); err != nil {
+			return nil, apperror.BadRequest("Invalid cursor", nil, err)
+		}
+	}
+
+	search := strings.TrimSpace(p.Search)
+	if utf8.RuneCountInString(search) < minSearchLength {
+		return nil, apperror.BadRequest("Search must be at least 2 characters", nil, nil)
+	}
+
+	// Email and phone only match when their owner lets the caller see them,
+	// otherwise a search would tell whether they belong to an account
+	exactMatch := user.Or(
+		user.UsernameEQ(common.NormalizeUsername(search)),
+		user.And(
+			user.EmailEQ(strings.ToLower(search)),
+			setting.EmailVisibleTo(p.UserId),
+		),
+		user.And(
+			user.PhoneEQ(search),
+			setting.PhoneVisibleTo(p.UserId),
+		),
+	)
+
+	queryBuilder := client.User.
+		Query().
+		Where(
+			user.IDNEQ(p.UserId),
+			user.SuspendedAtIsNil(),
+			user.Not(user.HasBlocksWith(userblock.BlockedId(p.UserId))),
+			user.Not(user.HasBlockedByWith(userblock.BlockerId(p.UserId))),
+			user.Or(
+				predicate.UnaccentContainsFold(user.FieldFullname, search),
+				exactMatch,
+			),
+		).
+		Select(user.FieldFullname, user.FieldUsername, user.FieldEmail, user.FieldAvatar)
+	queryBuilder.Modify(func(s *sql.Selector) {
+		rank := rankExpr(s, search, exactMatch)
+		s.AppendSelectExprAs(rank, columnRank)
+		if c != nil {
+			s.Where(sql.P(func(b *sql.Builder) {
+				b.WriteString("(").Join(rank).WriteString(" < ").Arg(c.Rank).
+					WriteString(" OR (").Join(rank).WriteString(" = ").Arg(c.Rank).
+					WriteString(" AND ").Ident(s.C(user.FieldID)).WriteString(" < ").Arg(c.Id).
+					WriteString("))")
+			}))
+		}
+		s.OrderBy(sql.Desc(columnRank), sql.Desc(s.C(user.FieldID)))
+	})
+
+	// Fetch one extra row to know whether there is a next page.
+	rows, err := queryBuilder.Limit(limit + 1).All(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "User.Query() failed")
+	}
+
+	res := &pagination.KeysetResult[*ent.User]{
+		Rows:  rows,
+		Limit: limit,
+	}
+	if len(rows) > limit {
+		res.Rows = rows[:limit]
+		last := res.Rows[limit-1]
+
+		rank, err := last.Value(columnRank)
+		if err != nil {
+			return nil, errors.Wrap(err, "Value() failed")
+		}
+		lastRank, ok := rank.(float64)
+		if !ok {
+			return nil, errors.Newf("unexpected rank type %T", rank)
+		}
+
+		res.NextCursor, err = pagination.EncodeCursor(&searchCursor{
+			Rank: lastRank,
+			Id:   last.ID,
+		})
+		if err != nil {
+			return nil, errors.Wrap(err, "EncodeCursor failed")
+		}
+	}
+
+	err = d.setting.FilterUsers(ctx, client, &setting.FilterUsersParams{
+		ViewerId: p.UserId,
+		Users:    res.Rows,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// rankExpr puts exact email/username/phone matches first, then orders by trigram
+// similarity of the unaccented fullname. The result is cast to float8 so that
+// it round-trips through the cursor without losing precision.
+func rankExpr(s *sql.Selector, search string, exactMatch entpredicate.User) sql.Querier {
+	// Render exactMatch against the rows of s
+	match := sql.Dialect(s.Dialect()).Select().From(sql.Table(user.Table))
+	exactMatch(match)
+
+	return sql.ExprFunc(func(b *sql.Builder) {
+		b.WriteString("CASE WHEN ").Join(match.P()).
+			WriteString(" THEN 2 ELSE similarity(unaccent(").Ident(s.C(user.FieldFullname)).
+			WriteString("), unaccent(").Arg(search).
+			WriteString("))::float8 END")
+	})
+}
+
+type searchCursor struct {
+	Rank float64 `json:"rank"`
+	Id   int     `json:"id"`
+}
+
+type SearchParams struct {
+	UserId int
+	Search string
+	Limit  int
+	Cursor string
+}
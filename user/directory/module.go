@@ -0,0 +1,7 @@
+package directory
+
+import "go.uber.org/fx"
+
+var Module = fx.Module("directory",
+	fx.Provide(newDirectory, newRouter),
+)
@@ -0,0 +1,64 @@
+package directory
+
+import (
+	"backend/common/result"
+	"backend/database/ent"
+	"backend/http/pagination"
+	"backend/http/validation"
+	"backend/security/auth"
+	"backend/security/jwt"
+
+	"github.com/kataras/iris/v12"
+	"github.com/kataras/iris/v12/core/router"
+	"go.uber.org/fx"
+)
+
+type Router struct {
+	client    *ent.Client
+	directory *Directory
+}
+
+type routerParams struct {
+	fx.In
+	Client    *ent.Client
+	Directory *Directory
+}
+
+func newRouter(p routerParams) *Router {
+	return &Router{
+		client:    p.Client,
+		directory: p.Directory,
+	}
+}
+
+func (r *Router) Register(routerGroup router.Party) {
+	{
+		router := routerGroup.Party("/search")
+
+		requireUserRouter := router.Party("/", auth.RequireUser)
+
+		requireUserRouter.Get("/", validation.Validate[searchQuery](validation.ReadQuery), func(ctx iris.Context) {
+			query := ctx.Values().Get(string(validation.ReadQuery)).(*searchQuery)
+			claims := ctx.Values().Get(auth.KeyUserClaims).(*jwt.UserClaims)
+			res, err := r.directory.Search(ctx, r.client, &SearchParams{
+				UserId: claims.UserId,
+				Search: query.Search,
+				Limit:  query.Limit,
+				Cursor: query.Cursor,
+			})
+
+			if err != nil {
+				ctx.SetErr(err)
+				return
+			}
+
+			ctx.JSON(result.Success("", res))
+		})
+	}
+
+}
+
+type searchQuery struct {
+	pagination.KeysetQuery
+	Search string `query:"search" validate:"required"`
+}
@@ -2,7 +2,9 @@ package user
 
 import (
 	"backend/user/auth"
+	"backend/user/directory"
 	"backend/user/profile"
+	"backend/user/setting"
 
 	"go.uber.org/fx"
 )
@@ -10,5 +12,7 @@ import (
 var Module = fx.Module("user",
 	fx.Provide(newRouter),
 	auth.Module,
+	directory.Module,
 	profile.Module,
+	setting.Module,
 )
@@ -1,27 +1,36 @@
 package profile
 
 import (
+	"backend/apperror"
+	"backend/common"
+	"backend/config"
 	"backend/database/ent"
 	"backend/database/ent/user"
+	"backend/database/ent/userblock"
 	"backend/file"
+	"backend/user/setting"
 	"context"
+	"time"
 
 	"github.com/cockroachdb/errors"
 	"go.uber.org/fx"
 )
 
 type Profile struct {
-	file file.File
+	file    file.File
+	setting *setting.Setting
 }
 
 type profileParams struct {
 	fx.In
-	File file.File
+	File    file.File
+	Setting *setting.Setting
 }
 
 func newProfile(p profileParams) *Profile {
 	return &Profile{
-		file: p.File,
+		file:    p.File,
+		setting: p.Setting,
 	}
 }
 
@@ -34,6 +43,57 @@ func (profile *Profile) GetProfile(ctx context.Context, client *ent.Client, user
 		return nil, errors.New("User not found")
 	}
 
+	clearExpiredStatus(user)
+
+	return user, nil
+}
+
+func (profile *Profile) GetPublicProfile(ctx context.Context, client *ent.Client, p *GetPublicProfileParams) (*ent.User, error) {
+	if p.ViewerId == p.UserId {
+		return profile.GetProfile(ctx, client, p.UserId)
+	}
+
+	user, err := client.User.Query().
+		Where(
+			user.IDEQ(p.UserId),
+			user.SuspendedAtIsNil(),
+			user.Not(user.HasBlocksWith(userblock.BlockedId(p.ViewerId))),
+			user.Not(user.HasBlockedByWith(userblock.BlockerId(p.ViewerId))),
+		).
+		Select(
+			user.FieldFullname,
+			user.FieldUsername,
+			user.FieldEmail,
+			user.FieldPhone,
+			user.FieldAvatar,
+			user.FieldCover,
+			user.FieldBio,
+			user.FieldStatusText,
+			user.FieldStatusEmoji,
+			user.FieldStatusExpiresAt,
+			user.FieldTimezone,
+			user.FieldLocale,
+			user.FieldIsActive,
+			user.FieldLastActiveAt,
+		).
+		First(ctx)
+	if err != nil && !ent.IsNotFound(err) {
+		return nil, errors.Wrap(err, "User.Query() failed")
+	}
+	if user == nil {
+		return nil, apperror.NotFound("User not found", nil, nil)
+	}
+
+	clearExpiredStatus(user)
+
+	err = profile.setting.FilterUsers(ctx, client, &setting.FilterUsersParams{
+		ViewerId: p.ViewerId,
+		Users:    []*ent.User{user},
+	})
+	if err != nil {
+		return nil, err
+	}
+
 	return user, nil
 }
 
@@ -54,12 +114,40 @@ func (profile *Profile) UpdateProfile(ctx context.Context, client *ent.Client, p
 		updateBuilder.SetPhone(p.Phone)
 	}
 	if p.Avatar != "" {
-		avatar, err := profile.file.MoveFromTemporaryAndDeleteOldFile(p.Avatar, file.FolderUser, user.Avatar)
+		avatar, err := profile.file.MoveFromTemporaryAndDeleteOldFile(ctx, client, p.Avatar, file.FolderUser, user.Avatar)
 		if err != nil {
 			return nil, err
 		}
 		updateBuilder.SetAvatar(avatar)
 	}
+	if p.Cover != "" {
+		cover, err := profile.file.MoveFromTemporaryAndDeleteOldFile(ctx, client, p.Cover, file.FolderUser, user.Cover)
+		if err != nil {
+			return nil, err
+		}
+		updateBuilder.SetCover(cover)
+	}
+	if p.Bio != nil {
+		if *p.Bio == "" {
+			updateBuilder.ClearBio()
+		} else {
+			updateBuilder.SetBio(*p.Bio)
+		}
+	}
+	if p.Timezone != nil {
+		if *p.Timezone == "" {
+			updateBuilder.ClearTimezone()
+		} else {
+			updateBuilder.SetTimezone(*p.Timezone)
+		}
+	}
+	if p.Locale != nil {
+		if *p.Locale == "" {
+			updateBuilder.ClearLocale()
+		} else {
+			updateBuilder.SetLocale(*p.Locale)
+		}
+	}
 
 	user, err = updateBuilder.Save(ctx)
 	if err != nil {
@@ -68,9 +156,127 @@ func (profile *Profile) UpdateProfile(ctx context.Context, client *ent.Client, p
 	return user, nil
 }
 
+func (profile *Profile) UpdateStatus(ctx context.Context, client *ent.Client, p *UpdateStatusParams) (*ent.User, error) {
+	if p.ExpiresAt != nil && !p.ExpiresAt.After(time.Now()) {
+		return nil, apperror.BadRequest("Status expiry must be in the future", nil, nil)
+	}
+
+	updateBuilder := client.User.UpdateOneID(p.UserId).
+		SetStatusText(p.Text).
+		SetStatusEmoji(p.Emoji)
+	if p.ExpiresAt != nil {
+		updateBuilder.SetStatusExpiresAt(*p.ExpiresAt)
+	} else {
+		updateBuilder.ClearStatusExpiresAt()
+	}
+
+	user, err := updateBuilder.Save(ctx)
+	if err != nil {
+		if ent.IsNotFound(err) {
+			return nil, errors.New("User not found")
+		}
+		return nil, errors.Wrap(err, "User.Update() failed")
+	}
+
+	return user, nil
+}
+
+func (profile *Profile) ClearStatus(ctx context.Context, client *ent.Client, userId int) (*ent.User, error) {
+	user, err := client.User.UpdateOneID(userId).
+		ClearStatusText().
+		ClearStatusEmoji().
+		ClearStatusExpiresAt().
+		Save(ctx)
+	if err != nil {
+		if ent.IsNotFound(err) {
+			return nil, errors.New("User not found")
+		}
+		return nil, errors.Wrap(err, "User.Update() failed")
+	}
+
+	return user, nil
+}
+
+// clearExpiredStatus hides a custom status whose expiry has passed. Expired
+// statuses are not cleaned up in the database, they are filtered on read.
+func clearExpiredStatus(u *ent.User) {
+	if u.StatusExpiresAt != nil && u.StatusExpiresAt.Before(time.Now()) {
+		u.StatusText = ""
+		u.StatusEmoji = ""
+		u.StatusExpiresAt = nil
+	}
+}
+
+func (profile *Profile) UpdateUsername(ctx context.Context, client *ent.Client, p *UpdateUsernameParams) (*ent.User, error) {
+	username := common.NormalizeUsername(p.Username)
+	if err := common.ValidateUsername(username); err != nil {
+		return nil, apperror.BadRequest(err.Error(), nil, nil)
+	}
+
+	user, err := client.User.Query().Where(user.IDEQ(p.UserId)).First(ctx)
+	if err != nil && !ent.IsNotFound(err) {
+		return nil, errors.Wrap(err, "User.Query() failed")
+	}
+	if user == nil {
+		return nil, errors.New("User not found")
+	}
+
+	if user.Username != nil && *user.Username == username {
+		return user, nil
+	}
+
+	// Rate limit username changes so handles cannot be cycled or squatted quickly
+	if user.UsernameChangedAt != nil {
+		nextChangeAt := user.UsernameChangedAt.AddDate(0, 0, config.UsernameChangeIntervalInDay)
+		if time.Now().Before(nextChangeAt) {
+			return nil, apperror.BadRequest(messageUsernameChangeTooSoon, map[string]time.Time{
+				"nextChangeAt": nextChangeAt,
+			}, nil)
+		}
+	}
+
+	user, err = user.Update().
+		SetUsername(username).
+		SetUsernameChangedAt(time.Now()).
+		Save(ctx)
+	if err != nil {
+		if ent.IsConstraintError(err) {
+			return nil, apperror.BadRequest(messageUsernameTaken, nil, err)
+		}
+		return nil, errors.Wrap(err, "User.Update() failed")
+	}
+	return user, nil
+}
+
+const (
+	messageUsernameTaken         = "Username is already taken"
+	messageUsernameChangeTooSoon = "Username was changed recently, please try again later"
+)
+
 type UpdateProfileParams struct {
 	UserId   int
 	Fullname string
 	Phone    string
 	Avatar   string
+	Cover    string
+	Bio      *string
+	Timezone *string
+	Locale   *string
+}
+
+type UpdateStatusParams struct {
+	UserId    int
+	Text      string
+	Emoji     string
+	ExpiresAt *time.Time
+}
+
+type GetPublicProfileParams struct {
+	ViewerId int
+	UserId   int
+}
+
+type UpdateUsernameParams struct {
+	UserId   int
+	Username string
 }
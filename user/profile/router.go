@@ -2,10 +2,13 @@ package profile
 
 import (
 	"backend/common/result"
+	"backend/database"
 	"backend/database/ent"
+	"backend/file"
 	"backend/http/validation"
 	"backend/security/auth"
 	"backend/security/jwt"
+	"time"
 
 	"github.com/kataras/iris/v12"
 	"github.com/kataras/iris/v12/core/router"
@@ -15,18 +18,21 @@ import (
 type Router struct {
 	client  *ent.Client
 	profile *Profile
+	quota   *file.Quota
 }
 
 type routerParams struct {
 	fx.In
 	Client  *ent.Client
 	Profile *Profile
+	Quota   *file.Quota
 }
 
 func newRouter(p routerParams) *Router {
 	return &Router{
 		client:  p.Client,
 		profile: p.Profile,
+		quota:   p.Quota,
 	}
 }
 
@@ -51,11 +57,68 @@ func (r *Router) Register(routerGroup router.Party) {
 		requireUserRouter.Patch("/", validation.Validate[updateProfileBody](validation.ReadBody), func(ctx iris.Context) {
 			claims := ctx.Values().Get(auth.KeyUserClaims).(*jwt.UserClaims)
 			body := ctx.Values().Get(string(validation.ReadBody)).(*updateProfileBody)
-			res, err := r.profile.UpdateProfile(ctx, r.client, &UpdateProfileParams{
+			err := database.WithTx(ctx, r.client, func(tx *ent.Tx) error {
+				res, err := r.profile.UpdateProfile(ctx, tx.Client(), &UpdateProfileParams{
+					UserId:   claims.UserId,
+					Fullname: body.Fullname,
+					Phone:    body.Phone,
+					Avatar:   body.Avatar,
+					Cover:    body.Cover,
+					Bio:      body.Bio,
+					Timezone: body.Timezone,
+					Locale:   body.Locale,
+				})
+
+				if err != nil {
+					return err
+				}
+
+				ctx.JSON(result.Success("Update success", res))
+				return nil
+			})
+
+			if err != nil {
+				ctx.SetErr(err)
+				return
+			}
+		})
+
+		requireUserRouter.Get("/storage", func(ctx iris.Context) {
+			claims := ctx.Values().Get(auth.KeyUserClaims).(*jwt.UserClaims)
+			res, err := r.quota.GetUsage(ctx, r.client, claims.UserId)
+
+			if err != nil {
+				ctx.SetErr(err)
+				return
+			}
+
+			ctx.JSON(result.Success("", res))
+		})
+
+		requireUserRouter.Put("/username", validation.Validate[updateUsernameBody](validation.ReadBody), func(ctx iris.Context) {
+			claims := ctx.Values().Get(auth.KeyUserClaims).(*jwt.UserClaims)
+			body := ctx.Values().Get(string(validation.ReadBody)).(*updateUsernameBody)
+			res, err := r.profile.UpdateUsername(ctx, r.client, &UpdateUsernameParams{
 				UserId:   claims.UserId,
-				Fullname: body.Fullname,
-				Phone:    body.Phone,
-				Avatar:   body.Avatar,
+				Username: body.Username,
+			})
+
+			if err != nil {
+				ctx.SetErr(err)
+				return
+			}
+
+			ctx.JSON(result.Success("Update success", res))
+		})
+
+		requireUserRouter.Put("/status", validation.Validate[updateStatusBody](validation.ReadBody), func(ctx iris.Context) {
+			claims := ctx.Values().Get(auth.KeyUserClaims).(*jwt.UserClaims)
+			body := ctx.Values().Get(string(validation.ReadBody)).(*updateStatusBody)
+			res, err := r.profile.UpdateStatus(ctx, r.client, &UpdateStatusParams{
+				UserId:    claims.UserId,
+				Text:      body.Text,
+				Emoji:     body.Emoji,
+				ExpiresAt: body.ExpiresAt,
 			})
 
 			if err != nil {
@@ -65,6 +128,38 @@ func (r *Router) Register(routerGroup router.Party) {
 
 			ctx.JSON(result.Success("Update success", res))
 		})
+
+		requireUserRouter.Delete("/status", func(ctx iris.Context) {
+			claims := ctx.Values().Get(auth.KeyUserClaims).(*jwt.UserClaims)
+			res, err := r.profile.ClearStatus(ctx, r.client, claims.UserId)
+
+			if err != nil {
+				ctx.SetErr(err)
+				return
+			}
+
+			ctx.JSON(result.Success("Update success", res))
+		})
+	}
+
+	{
+		requireUserRouter := routerGroup.Party("/", auth.RequireUser)
+
+		requireUserRouter.Get("/{userId:int}", validation.Validate[getPublicProfileParams](validation.ReadParams), func(ctx iris.Context) {
+			claims := ctx.Values().Get(auth.KeyUserClaims).(*jwt.UserClaims)
+			params := ctx.Values().Get(string(validation.ReadParams)).(*getPublicProfileParams)
+			res, err := r.profile.GetPublicProfile(ctx, r.client, &GetPublicProfileParams{
+				ViewerId: claims.UserId,
+				UserId:   params.UserId,
+			})
+
+			if err != nil {
+				ctx.SetErr(err)
+				return
+			}
+
+			ctx.JSON(result.Success("", res))
+		})
 	}
 
 }
@@ -73,4 +168,23 @@ type updateProfileBody struct {
 	Fullname string `json:"fullname"`
 	Phone    string `json:"phone"`
 	Avatar   string `json:"avatar"`
+	Cover    string `json:"cover"`
+	// Left unchanged when omitted, cleared when empty
+	Bio      *string `json:"bio" validate:"omitempty,max=500"`
+	Timezone *string `json:"timezone" validate:"omitempty,timezone"`
+	Locale   *string `json:"locale" validate:"omitempty,bcp47_language_tag"`
+}
+
+type updateUsernameBody struct {
+	Username string `json:"username" validate:"required"`
+}
+
+type updateStatusBody struct {
+	Text      string     `json:"text" validate:"required_without=Emoji,max=100"`
+	Emoji     string     `json:"emoji" validate:"max=32"`
+	ExpiresAt *time.Time `json:"expiresAt"`
+}
+
+type getPublicProfileParams struct {
+	UserId int `param:"userId" validate:"required"`
 }
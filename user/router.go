@@ -2,27 +2,35 @@ package user
 
 import (
 	"backend/user/auth"
+	"backend/user/directory"
 	"backend/user/profile"
+	"backend/user/setting"
 
 	"github.com/kataras/iris/v12/core/router"
 	"go.uber.org/fx"
 )
 
 type Router struct {
-	authRouter    *auth.Router
-	profileRouter *profile.Router
+	authRouter      *auth.Router
+	directoryRouter *directory.Router
+	profileRouter   *profile.Router
+	settingRouter   *setting.Router
 }
 
 type routerParams struct {
 	fx.In
-	AuthRouter    *auth.Router
-	ProfileRouter *profile.Router
+	AuthRouter      *auth.Router
+	DirectoryRouter *directory.Router
+	ProfileRouter   *profile.Router
+	SettingRouter   *setting.Router
 }
 
 func newRouter(p routerParams) *Router {
 	return &Router{
-		authRouter:    p.AuthRouter,
-		profileRouter: p.ProfileRouter,
+		authRouter:      p.AuthRouter,
+		directoryRouter: p.DirectoryRouter,
+		profileRouter:   p.ProfileRouter,
+		settingRouter:   p.SettingRouter,
 	}
 }
 
@@ -31,6 +39,8 @@ func (r *Router) Register(routerGroup router.Party) {
 		router := routerGroup.Party("/user")
 
 		r.authRouter.Register(router)
+		r.directoryRouter.Register(router)
 		r.profileRouter.Register(router)
+		r.settingRouter.Register(router)
 	}
 }
@@ -0,0 +1,7 @@
+package setting
+
+import "go.uber.org/fx"
+
+var Module = fx.Module("setting",
+	fx.Provide(newSetting, newRouter),
+)
@@ -0,0 +1,81 @@
+package setting
+
+import (
+	"backend/common/result"
+	"backend/database/ent"
+	"backend/database/ent/schema/enum"
+	"backend/http/validation"
+	"backend/security/auth"
+	"backend/security/jwt"
+
+	"github.com/kataras/iris/v12"
+	"github.com/kataras/iris/v12/core/router"
+	"go.uber.org/fx"
+)
+
+type Router struct {
+	client  *ent.Client
+	setting *Setting
+}
+
+type routerParams struct {
+	fx.In
+	Client  *ent.Client
+	Setting *Setting
+}
+
+func newRouter(p routerParams) *Router {
+	return &Router{
+		client:  p.Client,
+		setting: p.Setting,
+	}
+}
+
+func (r *Router) Register(routerGroup router.Party) {
+	{
+		router := routerGroup.Party("/setting")
+
+		requireUserRouter := router.Party("/", auth.RequireUser)
+
+		requireUserRouter.Get("/", func(ctx iris.Context) {
+			claims := ctx.Values().Get(auth.KeyUserClaims).(*jwt.UserClaims)
+			res, err := r.setting.Get(ctx, r.client, claims.UserId)
+
+			if err != nil {
+				ctx.SetErr(err)
+				return
+			}
+
+			ctx.JSON(result.Success("", res))
+		})
+
+		requireUserRouter.Patch("/", validation.Validate[updateBody](validation.ReadBody), func(ctx iris.Context) {
+			claims := ctx.Values().Get(auth.KeyUserClaims).(*jwt.UserClaims)
+			body := ctx.Values().Get(string(validation.ReadBody)).(*updateBody)
+			res, err := r.setting.Update(ctx, r.client, &UpdateParams{
+				UserId:               claims.UserId,
+				LastActiveVisibility: body.LastActiveVisibility,
+				OnlineVisibility:     body.OnlineVisibility,
+				EmailVisibility:      body.EmailVisibility,
+				PhoneVisibility:      body.PhoneVisibility,
+				SendReadReceipts:     body.SendReadReceipts,
+			})
+
+			if err != nil {
+				ctx.SetErr(err)
+				return
+			}
+
+			ctx.JSON(result.Success("Update success", res))
+		})
+	}
+
+}
+
+type updateBody struct {
+	LastActiveVisibility *enum.Visibility `json:"lastActiveVisibility" validate:"omitempty,oneof=everyone contacts nobody"`
+	OnlineVisibility     *enum.Visibility `json:"onlineVisibility" validate:"omitempty,oneof=everyone contacts nobody"`
+	EmailVisibility      *enum.Visibility `json:"emailVisibility" validate:"omitempty,oneof=everyone contacts nobody"`
+	PhoneVisibility      *enum.Visibility `json:"phoneVisibility" validate:"omitempty,oneof=everyone contacts nobody"`
+	SendReadReceipts     *bool            `json:"sendReadReceipts"`
+}
@@ -0,0 +1,268 @@
+package setting
+
+import (
+	"backend/database/ent"
+	"backend/database/ent/conversation"
+	"backend/database/ent/conversationmember"
+	"backend/database/ent/predicate"
+	"backend/database/ent/schema/enum"
+	"backend/database/ent/user"
+	"backend/database/ent/usersetting"
+	"context"
+
+	"github.com/cockroachdb/errors"
+	"go.uber.org/fx"
+)
+
+type Setting struct {
+}
+
+type settingParams struct {
+	fx.In
+}
+
+func newSetting(p settingParams) *Setting {
+	return &Setting{}
+}
+
+func (s *Setting) Get(ctx context.Context, client *ent.Client, userId int) (*ent.UserSetting, error) {
+	settings, err := s.getMany(ctx, client, []int{userId})
+	if err != nil {
+		return nil, err
+	}
+
+	return settings[userId], nil
+}
+
+// Update creates the settings of the user on their first change. Only the
+// given fields are changed, the others keep their value or default.
+func (s *Setting) Update(ctx context.Context, client *ent.Client, p *UpdateParams) (*ent.UserSetting, error) {
+	id, err := client.UserSetting.Create().
+		SetUserID(p.UserId).
+		SetNillableLastActiveVisibility(p.LastActiveVisibility).
+		SetNillableOnlineVisibility(p.OnlineVisibility).
+		SetNillableEmailVisibility(p.EmailVisibility).
+		SetNillablePhoneVisibility(p.PhoneVisibility).
+		SetNillableSendReadReceipts(p.SendReadReceipts).
+		OnConflictColumns(usersetting.FieldUserId).
+		Update(func(u *ent.UserSettingUpsert) {
+			if p.LastActiveVisibility != nil {
+				u.UpdateLastActiveVisibility()
+			}
+			if p.OnlineVisibility != nil {
+				u.UpdateOnlineVisibility()
+			}
+			if p.EmailVisibility != nil {
+				u.UpdateEmailVisibility()
+			}
+			if p.PhoneVisibility != nil {
+				u.UpdatePhoneVisibility()
+			}
+			if p.SendReadReceipts != nil {
+				u.UpdateSendReadReceipts()
+			}
+			u.UpdateUpdatedAt()
+		}).
+		ID(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "UserSetting.Create() failed")
+	}
+
+	setting, err := client.UserSetting.Get(ctx, id)
+	if err != nil {
+		return nil, errors.Wrap(err, "UserSetting.Query() failed")
+	}
+
+	return setting, nil
+}
+
+// FilterUsers blanks the fields of users that their owners' privacy settings
+// hide from the viewer. The viewer always sees their own data.
+func (s *Setting) FilterUsers(ctx context.Context, client *ent.Client, p *FilterUsersParams) error {
+	var ids []int
+	for _, u := range p.Users {
+		if u != nil && u.ID != p.ViewerId {
+			ids = append(ids, u.ID)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	settings, err := s.getMany(ctx, client, ids)
+	if err != nil {
+		return err
+	}
+
+	contacts, err := s.getContacts(ctx, client, p.ViewerId, ids)
+	if err != nil {
+		return err
+	}
+
+	for _, u := range p.Users {
+		if u == nil || u.ID == p.ViewerId {
+			continue
+		}
+
+		setting := settings[u.ID]
+		_, isContact := contacts[u.ID]
+
+		if !canSee(setting.LastActiveVisibility, isContact) {
+			u.LastActiveAt = nil
+		}
+		if !canSee(setting.OnlineVisibility, isContact) {
+			u.IsActive = false
+		}
+		if !canSee(setting.EmailVisibility, isContact) {
+			u.Email = ""
+		}
+		if !canSee(setting.PhoneVisibility, isContact) {
+			u.Phone = ""
+		}
+	}
+
+	return nil
+}
+
+// EmailVisibleTo matches the users whose email viewerId may see, the query
+// counterpart of FilterUsers.
+func EmailVisibleTo(viewerId int) predicate.User {
+	return visibleTo(viewerId, defaultSetting(0).EmailVisibility, func(v enum.Visibility) predicate.UserSetting {
+		return usersetting.EmailVisibilityEQ(v)
+	})
+}
+
+// PhoneVisibleTo matches the users whose phone viewerId may see, the query
+// counterpart of FilterUsers.
+func PhoneVisibleTo(viewerId int) predicate.User {
+	return visibleTo(viewerId, defaultSetting(0).PhoneVisibility, func(v enum.Visibility) predicate.UserSetting {
+		return usersetting.PhoneVisibilityEQ(v)
+	})
+}
+
+// visibleTo matches the users whose setting makes a field visible to
+// viewerId, users without settings have the field at fallback.
+func visibleTo(viewerId int, fallback enum.Visibility, visibility func(enum.Visibility) predicate.UserSetting) predicate.User {
+	setTo := func(v enum.Visibility) predicate.User {
+		if v == fallback {
+			return user.Or(
+				user.HasSettingWith(visibility(v)),
+				user.Not(user.HasSetting()),
+			)
+		}
+		return user.HasSettingWith(visibility(v))
+	}
+
+	isContact := user.HasConversationMembersWith(
+		conversationmember.HasConversationWith(
+			conversation.HasMembersWith(
+				conversationmember.UserIdEQ(viewerId),
+			),
+		),
+	)
+
+	return user.Or(
+		user.IDEQ(viewerId),
+		setTo(enum.VisibilityEveryone),
+		user.And(setTo(enum.VisibilityContacts), isContact),
+	)
+}
+
+// GetReadReceipts reports, for each user, whether they send read receipts.
+func (s *Setting) GetReadReceipts(ctx context.Context, client *ent.Client, userIds []int) (map[int]bool, error) {
+	settings, err := s.getMany(ctx, client, userIds)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make(map[int]bool, len(settings))
+	for userId, setting := range settings {
+		res[userId] = setting.SendReadReceipts
+	}
+
+	return res, nil
+}
+
+// getMany returns the settings of every given user, falling back to the
+// defaults for users who never saved any.
+func (s *Setting) getMany(ctx context.Context, client *ent.Client, userIds []int) (map[int]*ent.UserSetting, error) {
+	rows, err := client.UserSetting.Query().Where(usersetting.UserIdIn(userIds...)).All(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "UserSetting.Query() failed")
+	}
+
+	res := make(map[int]*ent.UserSetting, len(userIds))
+	for _, row := range rows {
+		res[row.UserId] = row
+	}
+	for _, userId := range userIds {
+		if _, ok := res[userId]; !ok {
+			res[userId] = defaultSetting(userId)
+		}
+	}
+
+	return res, nil
+}
+
+// getContacts returns which of userIds share at least one conversation with viewerId.
+func (s *Setting) getContacts(ctx context.Context, client *ent.Client, viewerId int, userIds []int) (map[int]struct{}, error) {
+	contactIds, err := client.ConversationMember.Query().
+		Where(
+			conversationmember.UserIdIn(userIds...),
+			conversationmember.HasConversationWith(
+				conversation.HasMembersWith(
+					conversationmember.UserIdEQ(viewerId),
+				),
+			),
+		).
+		Unique(true).
+		Select(conversationmember.FieldUserId).
+		Ints(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "ConversationMember.Query() failed")
+	}
+
+	res := make(map[int]struct{}, len(contactIds))
+	for _, id := range contactIds {
+		res[id] = struct{}{}
+	}
+
+	return res, nil
+}
+
+// defaultSetting mirrors the defaults of the user_setting schema.
+func defaultSetting(userId int) *ent.UserSetting {
+	return &ent.UserSetting{
+		UserId:               userId,
+		LastActiveVisibility: enum.VisibilityEveryone,
+		OnlineVisibility:     enum.VisibilityEveryone,
+		EmailVisibility:      enum.VisibilityEveryone,
+		PhoneVisibility:      enum.VisibilityContacts,
+		SendReadReceipts:     true,
+	}
+}
+
+func canSee(visibility enum.Visibility, isContact bool) bool {
+	switch visibility {
+	case enum.VisibilityEveryone:
+		return true
+	case enum.VisibilityContacts:
+		return isContact
+	default:
+		return false
+	}
+}
+
+type UpdateParams struct {
+	UserId               int
+	LastActiveVisibility *enum.Visibility
+	OnlineVisibility     *enum.Visibility
+	EmailVisibility      *enum.Visibility
+	PhoneVisibility      *enum.Visibility
+	SendReadReceipts     *bool
+}
+
+type FilterUsersParams struct {
+	ViewerId int
+	Users    []*ent.User
+}
@@ -4,8 +4,14 @@ import (
 	"backend/apperror"
 	"backend/common/result"
 	"backend/database/ent"
+	"backend/database/ent/conversation"
+	"backend/database/ent/conversationmember"
+	"backend/database/ent/message"
+	"backend/database/ent/messagedelivery"
+	"backend/database/ent/schema/enum"
 	entuser "backend/database/ent/user"
 	"backend/security/jwt"
+	"backend/user/setting"
 	"context"
 	"time"
 
@@ -20,6 +26,7 @@ type Websocket struct {
 	jwt     jwt.Jwt
 	handler apperror.Handler
 	client  *ent.Client
+	setting *setting.Setting
 	signalr.Hub
 }
 
@@ -29,6 +36,7 @@ type websocketParams struct {
 	Jwt     jwt.Jwt
 	Handler apperror.Handler
 	Client  *ent.Client
+	Setting *setting.Setting
 }
 
 func newWebsocket(p websocketParams) *Websocket {
@@ -46,6 +54,7 @@ func newWebsocket(p websocketParams) *Websocket {
 		jwt:     p.Jwt,
 		handler: p.Handler,
 		client:  p.Client,
+		setting: p.Setting,
 	}
 }
 
@@ -56,12 +65,8 @@ func (w *Websocket) OnDisconnected(connectionID string) {
 	w.handler(func() error {
 		ctx := context.Background()
 
-		userData, ok := w.Items().Load("user")
-		if !ok {
-			return nil
-		}
-		user, ok := userData.(*ent.User)
-		if !ok {
+		user := w.connectedUser()
+		if user == nil {
 			return nil
 		}
 
@@ -71,7 +76,7 @@ func (w *Websocket) OnDisconnected(connectionID string) {
 		}
 
 		w.Items().Clear()
-		w.Clients().All().Send(eventUserConnection, "")
+		w.broadcastUserConnection(user)
 
 		return nil
 	})
@@ -103,18 +108,146 @@ func (w *Websocket) Connect(accessToken string) {
 			return errors.Wrap(err, "Update user failed")
 		}
 
+		// The ack carries the caller's own privacy settings so clients can render them
+		user.Edges.Setting, err = w.setting.Get(ctx, w.client, user.ID)
+		if err != nil {
+			return err
+		}
+
 		w.Groups().AddToGroup(strconv.Itoa(int(claims.UserId)), w.ConnectionID())
 		w.Clients().Caller().Send(target, result.Success("Connect success", user))
 
 		w.Items().Store("user", user)
-		w.Clients().All().Send(eventUserConnection, "")
+		w.broadcastUserConnection(user)
+
+		return nil
+	})
+}
+
+// AckMessage is invoked by a client once it received a message, marking the
+// message delivered to the connected user. The sender is told through
+// messageDelivered, with the aggregated status once every recipient acked.
+func (w *Websocket) AckMessage(messageId int) {
+	w.handler(func() error {
+		ctx := context.Background()
+
+		user := w.connectedUser()
+		if user == nil {
+			return nil
+		}
+
+		m, err := w.client.Message.
+			Query().
+			Where(
+				message.ID(messageId),
+				message.UserIdNEQ(user.ID),
+				message.HasConversationWith(
+					conversation.HasMembersWith(
+						conversationmember.UserId(user.ID),
+					),
+				),
+			).
+			First(ctx)
+		if err != nil && !ent.IsNotFound(err) {
+			return errors.Wrap(err, "Get message failed")
+		}
+		if m == nil {
+			return nil
+		}
+
+		err = w.client.MessageDelivery.Create().
+			SetMessageID(m.ID).
+			SetUserID(user.ID).
+			Exec(ctx)
+		if err != nil {
+			// Already acknowledged from another device
+			if ent.IsConstraintError(err) {
+				return nil
+			}
+			return errors.Wrap(err, "Create message delivery failed")
+		}
+
+		if m.Status == enum.MessageStatusSent {
+			recipientCount, err := w.client.ConversationMember.
+				Query().
+				Where(
+					conversationmember.ConversationId(m.ConversationId),
+					conversationmember.UserIdNEQ(m.UserId),
+				).
+				Count(ctx)
+			if err != nil {
+				return errors.Wrap(err, "Count members failed")
+			}
+			deliveredCount, err := w.client.MessageDelivery.
+				Query().
+				Where(messagedelivery.MessageId(m.ID)).
+				Count(ctx)
+			if err != nil {
+				return errors.Wrap(err, "Count deliveries failed")
+			}
+
+			if deliveredCount >= recipientCount {
+				// Guarded by status so a concurrent read is not downgraded
+				err = w.client.Message.Update().
+					Where(
+						message.ID(m.ID),
+						message.StatusEQ(enum.MessageStatusSent),
+					).
+					SetStatus(enum.MessageStatusDelivered).
+					Exec(ctx)
+				if err != nil {
+					return errors.Wrap(err, "Update message failed")
+				}
+				m.Status = enum.MessageStatusDelivered
+			}
+		}
+
+		w.Clients().Group(strconv.Itoa(m.UserId)).Send(EventMessageDelivered, result.Success("", &MessageDeliveredEvent{
+			ConversationId: m.ConversationId,
+			MessageId:      m.ID,
+			UserId:         user.ID,
+			Status:         m.Status,
+		}))
 
 		return nil
 	})
 }
 
+func (w *Websocket) connectedUser() *ent.User {
+	userData, ok := w.Items().Load("user")
+	if !ok {
+		return nil
+	}
+	user, _ := userData.(*ent.User)
+	return user
+}
+
+// broadcastUserConnection tells clients to refresh online users, unless the
+// user hides their online status from everyone.
+func (w *Websocket) broadcastUserConnection(user *ent.User) {
+	if user.Edges.Setting != nil && user.Edges.Setting.OnlineVisibility == enum.VisibilityNobody {
+		return
+	}
+	w.Clients().All().Send(eventUserConnection, "")
+}
+
 const (
-	eventUserConnection  = "userConnection"
-	EventMessageReceived = "messageReceived"
-	EventMessageSeen     = "messageSeen"
+	eventUserConnection   = "userConnection"
+	EventMessageReceived  = "messageReceived"
+	EventMessageSeen      = "messageSeen"
+	EventMentioned        = "mentioned"
+	EventMessagePinned    = "messagePinned"
+	EventMessageUnpinned  = "messageUnpinned"
+	EventMessageDeleted   = "messageDeleted"
+	EventPollUpdated      = "pollUpdated"
+	EventMessageUpdated   = "messageUpdated"
+	EventMessageDelivered = "messageDelivered"
+	EventDraftUpdated     = "draftUpdated"
 )
+
+type MessageDeliveredEvent struct {
+	ConversationId int                `json:"conversationId"`
+	MessageId      int                `json:"messageId"`
+	UserId         int                `json:"userId"`
+	Status         enum.MessageStatus `json:"status"`
+}